@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/infamousjoeg/summon-wpm/internal/agent"
+	"github.com/infamousjoeg/summon-wpm/internal/auth"
+	"github.com/infamousjoeg/summon-wpm/internal/cache"
+	"github.com/infamousjoeg/summon-wpm/internal/provider"
+)
+
+// agentSource serves agent requests from a provider holding the session in memory
+type agentSource struct {
+	*provider.Provider
+}
+
+// GetCredential answers a credential request
+func (s agentSource) GetCredential(id string, noCache bool) (string, error) {
+	if noCache {
+		return s.Provider.WithoutCache().GetCredential(id)
+	}
+	return s.Provider.GetCredential(id)
+}
+
+// GetCredentials answers a batch request
+func (s agentSource) GetCredentials(request agent.CredentialsRequest) ([]agent.CredentialsResult, error) {
+	p := s.Provider
+	if request.NoCache {
+		p = p.WithoutCache()
+	}
+	results, err := p.GetCredentials(request.IDs, request.Workers, request.FailFast)
+	if err != nil {
+		return nil, err
+	}
+
+	agentResults := make([]agent.CredentialsResult, len(results))
+	for i, result := range results {
+		agentResults[i] = agent.CredentialsResult{ID: result.ID, Value: result.Value}
+		var stale *cache.StaleError
+		if errors.As(result.Err, &stale) {
+			agentResults[i].Stale = stale
+		} else if result.Err != nil {
+			agentResults[i].Error = result.Err.Error()
+		}
+	}
+	return agentResults, nil
+}
+
+// ListApps answers an apps request
+func (s agentSource) ListApps(filter string) ([]auth.App, error) {
+	return s.Provider.ListApps(filter)
+}
+
+// runAgent implements `summon-wpm agent [--socket path] [--policy file]`. It
+// logs in once, keeps the session in memory and answers lookups on a Unix
+// socket until it is interrupted. With a policy, which needs --socket, the
+// socket is shared by all local users and each caller may only fetch the
+// apps the policy grants it.
+func runAgent(args []string, verbose bool) {
+	flags := flag.NewFlagSet("agent", flag.ExitOnError)
+	socketPath := flags.String("socket", agent.DefaultSocketPath(), "Path of the Unix socket to listen on")
+	policyFile := flags.String("policy", "", "Access policy mapping socket clients to the apps they may fetch")
+	flags.BoolVar(&verbose, "verbose", verbose, "Enable verbose output")
+	flags.Parse(args)
+
+	var policy *agent.Policy
+	if *policyFile != "" {
+		var err error
+		policy, err = agent.LoadPolicy(*policyFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading policy %s: %s\n", *policyFile, err)
+			os.Exit(1)
+		}
+	}
+
+	p, err := provider.NewMemoryProvider(verbose)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(1)
+	}
+
+	server, err := agent.Listen(*socketPath, agentSource{p}, policy)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(1)
+	}
+
+	// Shell commands to point clients at the agent, as ssh-agent prints
+	fmt.Printf("%s=%s; export %s;\n", agent.SocketEnvVar, *socketPath, agent.SocketEnvVar)
+	fmt.Fprintf(os.Stderr, "Agent listening on %s\n", *socketPath)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		server.Close()
+	}()
+
+	if err := server.Serve(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(1)
+	}
+}
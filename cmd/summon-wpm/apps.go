@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/infamousjoeg/summon-wpm/internal/auth"
+	"github.com/infamousjoeg/summon-wpm/internal/provider"
+)
+
+// appsListing is the JSON form of an application in `apps list --json`
+type appsListing struct {
+	AppKey        string `json:"app_key"`
+	DisplayName   string `json:"display_name"`
+	Type          string `json:"type"`
+	CredentialSet bool   `json:"credential_set"`
+}
+
+// runApps implements `summon-wpm apps list [--filter <text>] [--json]`
+func runApps(args []string, verbose bool) {
+	if len(args) == 0 || args[0] != "list" {
+		fmt.Fprintln(os.Stderr, "Usage: summon-wpm apps list [--filter <text>] [--json]")
+		os.Exit(1)
+	}
+
+	flags := flag.NewFlagSet("apps list", flag.ExitOnError)
+	filter := flags.String("filter", "", "Only list apps whose key, name or type contains this text")
+	jsonOutput := flags.Bool("json", false, "Print the apps as JSON")
+	flags.BoolVar(&verbose, "verbose", verbose, "Enable verbose output")
+	flags.Parse(args[1:])
+
+	apps, err := provider.NewProvider(verbose).ListApps(*filter)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(1)
+	}
+
+	if *jsonOutput {
+		printAppsJSON(apps)
+	} else {
+		printAppsTable(apps)
+	}
+}
+
+// printAppsJSON prints the apps as a JSON array
+func printAppsJSON(apps []auth.App) {
+	listing := make([]appsListing, 0, len(apps))
+	for _, app := range apps {
+		listing = append(listing, appsListing{
+			AppKey:        app.AppKey,
+			DisplayName:   app.Title(),
+			Type:          app.Type(),
+			CredentialSet: app.CredentialSet,
+		})
+	}
+
+	data, err := json.MarshalIndent(listing, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Println(string(data))
+}
+
+// printAppsTable prints the apps as an aligned table
+func printAppsTable(apps []auth.App) {
+	if len(apps) == 0 {
+		fmt.Fprintln(os.Stderr, "No apps found")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "APP KEY\tNAME\tTYPE\tCREDENTIAL")
+	for _, app := range apps {
+		credential := "no"
+		if app.CredentialSet {
+			credential = "yes"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", app.AppKey, app.Title(), app.Type(), credential)
+	}
+	w.Flush()
+}
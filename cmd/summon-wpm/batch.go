@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/infamousjoeg/summon-wpm/internal/provider"
+	"github.com/infamousjoeg/summon-wpm/internal/secretsyml"
+)
+
+// Batch output formats
+const (
+	batchFormatJSON = "json"
+	batchFormatNUL  = "nul"
+)
+
+// batchOptions are the flags of --batch mode
+type batchOptions struct {
+	secretsFile string
+	environment string
+	defines     defineFlags
+	format      string
+	workers     int
+	failFast    bool
+	// staleExitCode exits with exitStale when a value came from the cache
+	staleExitCode bool
+}
+
+// batchItem is one requested credential
+type batchItem struct {
+	Name string `json:"name,omitempty"`
+	ID   string `json:"id"`
+	// secret is the secrets.yml entry the ID came from, if any
+	secret *secretsyml.Secret
+}
+
+// batchOutput is one result in JSON output
+type batchOutput struct {
+	batchItem
+	Value string `json:"value,omitempty"`
+	// Stale marks a value served from the cache because the tenant could
+	// not be reached
+	Stale bool   `json:"stale,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// runBatch fetches many credentials in one process. IDs are read one per
+// line from stdin, or from the !var entries of a secrets.yml file.
+func runBatch(p *provider.Provider, opts batchOptions) {
+	if opts.format != batchFormatJSON && opts.format != batchFormatNUL {
+		fmt.Fprintf(os.Stderr, "Error: unknown format %q (use json or nul)\n", opts.format)
+		os.Exit(1)
+	}
+
+	var items []batchItem
+	var err error
+	if opts.secretsFile != "" {
+		items, err = readBatchSecrets(opts.secretsFile, opts.environment, opts.defines)
+	} else {
+		items, err = readBatchIDs(os.Stdin)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(1)
+	}
+
+	ids := make([]string, len(items))
+	for i, item := range items {
+		ids[i] = item.ID
+	}
+
+	results, err := p.GetCredentials(ids, opts.workers, opts.failFast)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(1)
+	}
+
+	failed, stale := false, false
+	outputs := make([]batchOutput, len(results))
+	for i, result := range results {
+		outputs[i] = batchOutput{batchItem: items[i], Value: result.Value}
+		if isStale(result.Err) {
+			outputs[i].Stale = true
+			stale = true
+		} else if result.Err != nil && items[i].secret != nil && items[i].secret.HasDefault {
+			outputs[i].Value = items[i].secret.Default
+		} else if result.Err != nil {
+			outputs[i].Error = result.Err.Error()
+			failed = true
+		}
+	}
+
+	if opts.format == batchFormatJSON {
+		data, err := json.MarshalIndent(outputs, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(data))
+	} else {
+		writeBatchNUL(os.Stdout, outputs)
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+	if stale && opts.staleExitCode {
+		os.Exit(exitStale)
+	}
+	os.Exit(0)
+}
+
+// writeBatchNUL writes each success as key NUL value NUL, where the key is the
+// variable name from secrets.yml or else the ID. Failures go to stderr.
+func writeBatchNUL(w io.Writer, outputs []batchOutput) {
+	for _, output := range outputs {
+		key := output.Name
+		if key == "" {
+			key = output.ID
+		}
+		if output.Error != "" {
+			fmt.Fprintf(os.Stderr, "Error: %s: %s\n", key, output.Error)
+			continue
+		}
+		fmt.Fprintf(w, "%s\x00%s\x00", key, output.Value)
+	}
+}
+
+// readBatchIDs reads one ID per line, skipping blank lines and # comments
+func readBatchIDs(r io.Reader) ([]batchItem, error) {
+	var items []batchItem
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		items = append(items, batchItem{ID: line})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading IDs: %s", err)
+	}
+	return items, nil
+}
+
+// readBatchSecrets returns the variables of a secrets.yml file, read with the
+// same environment and defines as exec
+func readBatchSecrets(path, environment string, defines map[string]string) ([]batchItem, error) {
+	secrets, err := secretsyml.ParseFile(path, environment, defines)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []batchItem
+	for i, secret := range secrets {
+		if secret.IsVar {
+			items = append(items, batchItem{Name: secret.Name, ID: secret.Value, secret: &secrets[i]})
+		}
+	}
+	return items, nil
+}
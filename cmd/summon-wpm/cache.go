@@ -0,0 +1,30 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/infamousjoeg/summon-wpm/internal/cache"
+)
+
+// runCache implements `summon-wpm cache purge`
+func runCache(args []string, verbose bool) {
+	if len(args) == 0 || args[0] != "purge" {
+		fmt.Fprintln(os.Stderr, "Usage: summon-wpm cache purge")
+		os.Exit(1)
+	}
+
+	flags := flag.NewFlagSet("cache purge", flag.ExitOnError)
+	flags.Parse(args[1:])
+
+	dir := cache.Dir()
+	if verbose {
+		fmt.Fprintf(os.Stderr, "Purging credential cache in %s\n", dir)
+	}
+	if err := cache.Purge(dir); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Println("Credential cache purged:", dir)
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+
+	"github.com/infamousjoeg/summon-wpm/internal/provider"
+	"github.com/infamousjoeg/summon-wpm/internal/secretsyml"
+)
+
+// defineFlags collects repeated -D NAME=VALUE flags
+type defineFlags map[string]string
+
+func (d defineFlags) String() string { return "" }
+
+func (d defineFlags) Set(value string) error {
+	name, val, ok := strings.Cut(value, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("expected NAME=VALUE, got %q", value)
+	}
+	d[name] = val
+	return nil
+}
+
+// runExec implements `summon-wpm exec [-f secrets.yml] -- command args`. The
+// secrets are resolved with the provider and handed to the child as
+// environment variables or temp files, which are removed when it exits.
+func runExec(args []string, verbose bool) {
+	defines := defineFlags{}
+	flags := flag.NewFlagSet("exec", flag.ExitOnError)
+	secretsFile := flags.String("f", "secrets.yml", "Path to the secrets.yml file")
+	environment := flags.String("e", "", "Section of secrets.yml to use")
+	flags.BoolVar(&verbose, "verbose", verbose, "Enable verbose output")
+	flags.Var(defines, "D", "Define a value for $NAME in secrets.yml (repeatable)")
+	flags.Parse(args)
+
+	command := flags.Args()
+	if len(command) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: summon-wpm exec [-f secrets.yml] [-e environment] [-D NAME=VALUE] -- command [args...]")
+		os.Exit(1)
+	}
+
+	secrets, err := secretsyml.ParseFile(*secretsFile, *environment, defines)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(1)
+	}
+
+	tempFiles := &tempFileSet{}
+	env, err := resolveSecrets(provider.NewProvider(verbose), secrets, tempFiles)
+	if err != nil {
+		tempFiles.Remove()
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(1)
+	}
+
+	code := runChild(command, append(os.Environ(), env...))
+	tempFiles.Remove()
+	os.Exit(code)
+}
+
+// resolveSecrets looks up the variables and returns NAME=value entries for
+// the child's environment
+func resolveSecrets(p *provider.Provider, secrets []secretsyml.Secret, tempFiles *tempFileSet) ([]string, error) {
+	var env []string
+	for _, secret := range secrets {
+		value := secret.Value
+		if secret.IsVar {
+			// A stale credential has been warned about and is still used
+			credential, err := p.GetCredential(secret.Value)
+			switch {
+			case err == nil || isStale(err):
+				value = credential
+			case secret.HasDefault:
+				value = secret.Default
+			default:
+				return nil, fmt.Errorf("%s: %s", secret.Name, err)
+			}
+		}
+
+		if secret.IsFile {
+			path, err := tempFiles.Write(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", secret.Name, err)
+			}
+			value = path
+		}
+
+		env = append(env, secret.Name+"="+value)
+	}
+	return env, nil
+}
+
+// runChild runs the command with the given environment, passing signals on,
+// and returns its exit code
+func runChild(command []string, env []string) int {
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Env = env
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		return 127
+	}
+
+	// Signals go to the child; we wait for it to exit and then clean up
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, forwardedSignals...)
+	defer signal.Stop(signals)
+	go func() {
+		for sig := range signals {
+			cmd.Process.Signal(sig)
+		}
+	}()
+
+	err := cmd.Wait()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if code := exitErr.ExitCode(); code >= 0 {
+			return code
+		}
+		return 1
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+// tempFileSet tracks the temp files holding secrets for the child
+type tempFileSet struct {
+	paths []string
+}
+
+// Write stores value in a new temp file readable only by the current user,
+// in memory-backed /dev/shm when available
+func (t *tempFileSet) Write(value string) (string, error) {
+	dir := ""
+	if info, err := os.Stat("/dev/shm"); err == nil && info.IsDir() {
+		dir = "/dev/shm"
+	}
+
+	file, err := os.CreateTemp(dir, "summon-wpm-")
+	if err != nil {
+		return "", fmt.Errorf("error creating temp file: %s", err)
+	}
+	t.paths = append(t.paths, file.Name())
+
+	if err := file.Chmod(0600); err != nil {
+		file.Close()
+		return "", fmt.Errorf("error securing temp file: %s", err)
+	}
+	if _, err := file.WriteString(value); err != nil {
+		file.Close()
+		return "", fmt.Errorf("error writing temp file: %s", err)
+	}
+	if err := file.Close(); err != nil {
+		return "", fmt.Errorf("error writing temp file: %s", err)
+	}
+	return file.Name(), nil
+}
+
+// Remove deletes the temp files
+func (t *tempFileSet) Remove() {
+	for _, path := range t.paths {
+		os.Remove(path)
+	}
+	t.paths = nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/infamousjoeg/summon-wpm/internal/auth"
+	"github.com/infamousjoeg/summon-wpm/internal/config"
+)
+
+// runLogout implements `summon-wpm logout [--all-profiles]`
+func runLogout(args []string, verbose bool) {
+	flags := flag.NewFlagSet("logout", flag.ExitOnError)
+	allProfiles := flags.Bool("all-profiles", false, "Log out of every profile")
+	flags.Parse(args)
+
+	profiles := map[string]string{config.ActiveProfile(): config.GetConfigFilePath()}
+	if *allProfiles {
+		var err error
+		profiles, err = config.ProfileFiles()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			os.Exit(1)
+		}
+		if len(profiles) == 0 {
+			fmt.Println("No profiles found")
+			os.Exit(0)
+		}
+	}
+
+	failed := false
+	for _, profile := range config.ProfileNames(profiles) {
+		if verbose {
+			fmt.Fprintf(os.Stderr, "[%s] Logging out with %s\n", profile, profiles[profile])
+		}
+		if !logoutProfile(profile, profiles[profile]) {
+			failed = true
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// logoutProfile logs one profile out and reports what was revoked. It
+// returns false when the profile could not be logged out cleanly.
+func logoutProfile(profile, configFile string) bool {
+	cfg, err := config.LoadConfig(configFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			fmt.Printf("[%s] Not configured\n", profile)
+			return true
+		}
+		fmt.Fprintf(os.Stderr, "[%s] Error loading config: %s\n", profile, err)
+		return false
+	}
+
+	result, err := auth.Logout(cfg, configFile)
+	for _, revoked := range result.Revoked {
+		fmt.Printf("[%s] Revoked %s\n", profile, revoked)
+	}
+
+	failures := make([]string, 0, len(result.Failed))
+	for what := range result.Failed {
+		failures = append(failures, what)
+	}
+	sort.Strings(failures)
+	for _, what := range failures {
+		fmt.Fprintf(os.Stderr, "[%s] Warning: could not revoke %s: %s\n", profile, what, result.Failed[what])
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[%s] Error: %s\n", profile, err)
+		return false
+	}
+
+	for _, removed := range result.Removed {
+		fmt.Printf("[%s] Removed %s\n", profile, removed)
+	}
+	if len(result.Revoked) == 0 && len(result.Removed) == 0 {
+		fmt.Printf("[%s] Already logged out\n", profile)
+	} else {
+		fmt.Printf("[%s] Logged out\n", profile)
+	}
+	return true
+}
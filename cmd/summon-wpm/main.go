@@ -1,19 +1,30 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"os"
 
 	"github.com/infamousjoeg/summon-wpm/internal/auth"
+	"github.com/infamousjoeg/summon-wpm/internal/cache"
+	"github.com/infamousjoeg/summon-wpm/internal/clipboard"
 	"github.com/infamousjoeg/summon-wpm/internal/config"
+	"github.com/infamousjoeg/summon-wpm/internal/picker"
 	"github.com/infamousjoeg/summon-wpm/internal/provider"
+	"github.com/infamousjoeg/summon-wpm/internal/selector"
 )
 
 const version = "0.1.0"
 
+// exitStale is the exit code with --stale-exit-code when a credential was
+// served from the cache because the tenant could not be reached
+const exitStale = 3
+
 func main() {
-	var showHelp, showVersion, configureFlag, loginFlag, verbose bool
+	var mechanism, profile string
+	batch := batchOptions{defines: defineFlags{}}
+	var showHelp, showVersion, configureFlag, loginFlag, browserFlag, deviceFlag, fixPermissions, copyFlag, batchFlag, staleExitCode, verbose bool
 
 	flag.BoolVar(&showHelp, "h", false, "Show help")
 	flag.BoolVar(&showHelp, "help", false, "Show help")
@@ -21,7 +32,22 @@ func main() {
 	flag.BoolVar(&showVersion, "version", false, "Show version")
 	flag.BoolVar(&configureFlag, "config", false, "Configure the provider")
 	flag.BoolVar(&loginFlag, "login", false, "Login to CyberArk Identity")
+	flag.BoolVar(&browserFlag, "browser", false, "Login through the browser with OAuth2 authorization code + PKCE")
+	flag.BoolVar(&deviceFlag, "device", false, "Login with the OAuth2 device code flow (headless hosts)")
+	flag.StringVar(&mechanism, "mechanism", "", "Authentication mechanism to use for this login (e.g. OATH)")
+	flag.BoolVar(&fixPermissions, "fix-permissions", false, "Restrict config file permissions to the current user")
+	flag.BoolVar(&copyFlag, "copy", false, "Copy the credential to the clipboard instead of printing it")
+	flag.BoolVar(&batchFlag, "batch", false, "Fetch many credentials, reading app IDs from stdin or --secrets")
+	flag.StringVar(&batch.secretsFile, "secrets", "", "With --batch, read the app IDs from this secrets.yml")
+	flag.StringVar(&batch.environment, "e", "", "With --batch --secrets, section of secrets.yml to use")
+	flag.Var(batch.defines, "D", "With --batch --secrets, define a value for $NAME in secrets.yml (repeatable)")
+	flag.StringVar(&batch.format, "format", batchFormatJSON, "With --batch, output format: json or nul")
+	flag.IntVar(&batch.workers, "workers", provider.DefaultWorkers, "With --batch, how many credentials to fetch at once (at most 64)")
+	flag.BoolVar(&batch.failFast, "fail-fast", false, "With --batch, stop at the first failure")
+	flag.BoolVar(&provider.NoCache, "no-cache", false, "Bypass the credential cache")
+	flag.BoolVar(&staleExitCode, "stale-exit-code", false, "Exit with code 3 when a credential was served stale from the cache")
 	flag.BoolVar(&verbose, "verbose", false, "Enable verbose output")
+	flag.StringVar(&profile, "profile", "", "Configuration profile to use (default: $SUMMON_WPM_PROFILE or \"default\")")
 
 	flag.Parse()
 
@@ -35,8 +61,35 @@ func main() {
 		os.Exit(0)
 	}
 
+	if profile != "" {
+		config.Profile = profile
+	}
+	if err := config.ValidateProfile(config.ActiveProfile()); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(1)
+	}
+
+	// Subcommands are the first argument after the global options. After a
+	// "--" separator the argument is always an app ID, so apps named like a
+	// subcommand can still be fetched.
+	if args := flag.Args(); len(args) > 0 && !afterSeparator(args) {
+		if run, ok := subcommands[args[0]]; ok {
+			run(args[1:], verbose)
+			return
+		}
+	}
+
 	configFile := config.GetConfigFilePath()
 
+	if fixPermissions {
+		if err := config.FixPermissions(configFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error fixing permissions: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Println("Permissions fixed for:", configFile)
+		os.Exit(0)
+	}
+
 	if configureFlag {
 		config.RunConfigWizard(configFile)
 		os.Exit(0)
@@ -56,9 +109,21 @@ func main() {
 			}
 		}
 
-		forceInteractive := !(cfg.ClientID != "" && cfg.ClientSecret != "")
+		if err := config.ResolveTenant(cfg, configFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error resolving tenant: %s\n", err)
+			os.Exit(1)
+		}
+
+		if browserFlag {
+			err = auth.AuthenticateWithBrowser(cfg, configFile)
+		} else if deviceFlag {
+			err = auth.AuthenticateWithDeviceCode(cfg, configFile)
+		} else {
+			forceInteractive := !auth.HasServiceCredentials(cfg)
+			err = auth.Authenticate(cfg, configFile, forceInteractive, auth.InteractiveOptions{Mechanism: mechanism})
+		}
 
-		if err := auth.Authenticate(cfg, configFile, forceInteractive); err != nil {
+		if err != nil {
 			fmt.Fprintf(os.Stderr, "Authentication failed: %s\n", err)
 			os.Exit(1)
 		}
@@ -67,29 +132,112 @@ func main() {
 		os.Exit(0)
 	}
 
+	// Create the provider
+	p := provider.NewProvider(verbose)
+
+	if batchFlag {
+		batch.staleExitCode = staleExitCode
+		runBatch(p, batch)
+		return
+	}
+
 	// Get the variable name from command line arguments
 	args := flag.Args()
-	if len(args) != 1 {
+	var appID string
+	switch {
+	case len(args) == 1:
+		appID = args[0]
+	case len(args) == 0 && auth.IsInteractive():
+		// A person at a terminal picks the app from a list
+		appID = pickApp(p)
+	default:
 		showUsage()
 		os.Exit(1)
 	}
 
-	appID := args[0]
-
 	if verbose {
 		fmt.Fprintf(os.Stderr, "Looking up app credentials for: %s\n", appID)
 	}
 
-	// Create the provider and execute it
-	p := provider.NewProvider(verbose)
-	result, err := p.GetCredential(appID)
-	if err != nil {
+	// A single app ID is taken whole; field selectors are only read in --batch
+	// and secrets.yml
+	result, err := p.GetCredential(selector.Format(appID, ""))
+	exitCode := 0
+	if isStale(err) {
+		// The provider has warned; the value is still printed. Summon fails
+		// on any non-zero exit code, so only exit 3 when asked to.
+		if staleExitCode {
+			exitCode = exitStale
+		}
+	} else if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
 		os.Exit(1)
 	}
 
+	if copyFlag {
+		if err := clipboard.Copy(result); err != nil {
+			fmt.Fprintf(os.Stderr, "Error copying to clipboard: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Fprintln(os.Stderr, "Credential copied to the clipboard")
+		os.Exit(exitCode)
+	}
+
 	// Success! Output the password to stdout
 	fmt.Print(result)
+	os.Exit(exitCode)
+}
+
+// isStale reports whether err marks a credential served from the cache
+func isStale(err error) bool {
+	var stale *cache.StaleError
+	return errors.As(err, &stale)
+}
+
+// pickApp lets the user choose one of their apps with a fuzzy search and
+// returns its app key
+func pickApp(p *provider.Provider) string {
+	apps, err := p.ListApps("")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(1)
+	}
+	if len(apps) == 0 {
+		fmt.Fprintln(os.Stderr, "No apps found")
+		os.Exit(1)
+	}
+
+	labels := make([]string, len(apps))
+	for i, app := range apps {
+		labels[i] = fmt.Sprintf("%s  [%s]  %s", app.Title(), app.Type(), app.AppKey)
+	}
+
+	index, err := picker.Pick("App: ", labels)
+	if err != nil {
+		if err == picker.ErrCancelled {
+			os.Exit(130)
+		}
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(1)
+	}
+	return apps[index].AppKey
+}
+
+// afterSeparator reports whether the remaining arguments followed a "--"
+func afterSeparator(args []string) bool {
+	i := len(os.Args) - len(args) - 1
+	return i > 0 && os.Args[i] == "--"
+}
+
+// subcommands maps each subcommand name to its implementation, which is
+// passed the global --verbose
+var subcommands = map[string]func(args []string, verbose bool){
+	"agent":  runAgent,
+	"apps":   runApps,
+	"cache":  runCache,
+	"exec":   runExec,
+	"logout": runLogout,
+	"status": runStatus,
 }
 
 func showUsage() {
@@ -97,13 +245,46 @@ func showUsage() {
 	fmt.Println()
 	fmt.Println("Usage:")
 	fmt.Println("  summon-wpm [options] <app_id>")
+	fmt.Println("  summon-wpm [options] -- <app_id>  (for an app named like a command)")
+	fmt.Println("  summon-wpm [options]            (on a terminal: pick the app from a list)")
+	fmt.Println("  summon-wpm [options] --batch [--secrets secrets.yml [-e env] [-D NAME=VALUE]] < app_ids")
+	fmt.Println("  summon-wpm [options] agent [--socket path] [--policy file]")
+	fmt.Println("  summon-wpm [options] logout [--all-profiles]")
+	fmt.Println("  summon-wpm [options] status [--json]")
+	fmt.Println("  summon-wpm [options] apps list [--filter <text>] [--json]")
+	fmt.Println("  summon-wpm [options] cache purge")
+	fmt.Println("  summon-wpm [options] exec [-f secrets.yml] [-e env] [-D NAME=VALUE] -- command [args...]")
 	fmt.Println()
 	fmt.Println("Options:")
 	fmt.Println("  -h, --help     Show this help message")
 	fmt.Println("  -v, --version  Show version information")
 	fmt.Println("  --config       Run the configuration wizard")
 	fmt.Println("  --login        Login to CyberArk Identity")
+	fmt.Println("  --browser      With --login, sign in through the browser (OAuth2 + PKCE)")
+	fmt.Println("  --device       With --login, sign in from another device (OAuth2 device code)")
+	fmt.Println("  --mechanism    With --login, use this MFA mechanism instead of the preferred ones")
+	fmt.Println("  --fix-permissions  Restrict the config file to the current user")
+	fmt.Println("  --copy         Copy the credential to the clipboard instead of printing it")
+	fmt.Println("  --batch        Fetch many credentials at once (app IDs from stdin or --secrets)")
+	fmt.Println("  --secrets      With --batch, read the app IDs from a secrets.yml file")
+	fmt.Println("  -e, -D         With --batch --secrets, the secrets.yml section and defines, as for exec")
+	fmt.Println("  --format       With --batch, output json (default) or nul (name NUL value NUL)")
+	fmt.Println("  --workers      With --batch, credentials fetched at once (default 8)")
+	fmt.Println("  --fail-fast    With --batch, stop at the first failure")
+	fmt.Println("  --no-cache     Fetch from the tenant even if the credential is cached")
+	fmt.Println("  --stale-exit-code  Exit with code 3 when a credential is served stale from the cache")
+	fmt.Println("  --profile      Use a named configuration profile (or set SUMMON_WPM_PROFILE)")
 	fmt.Println("  --verbose      Enable verbose output")
 	fmt.Println()
+	fmt.Println("Commands:")
+	fmt.Println("  agent          Hold the session in memory and answer lookups on a Unix socket;")
+	fmt.Println("                 other commands use it when SUMMON_WPM_AGENT_SOCK is set")
+	fmt.Println("  apps list      List the apps available to you; the app key is the <app_id>")
+	fmt.Println("  cache purge    Remove all cached credentials and the cache key")
+	fmt.Println("  exec           Run a command with the secrets from secrets.yml, without summon")
+	fmt.Println("  logout         Revoke the profile's tokens and remove them locally")
+	fmt.Println("                 (--all-profiles logs out of every profile)")
+	fmt.Println("  status         Show the profile's session and the identity the tenant sees")
+	fmt.Println()
 	fmt.Println("For use with Summon (https://github.com/cyberark/summon)")
 }
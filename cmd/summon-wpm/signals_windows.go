@@ -0,0 +1,8 @@
+//go:build windows
+
+package main
+
+import "os"
+
+// forwardedSignals are passed on to a child started by exec
+var forwardedSignals = []os.Signal{os.Interrupt}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/infamousjoeg/summon-wpm/internal/agent"
+	"github.com/infamousjoeg/summon-wpm/internal/auth"
+	"github.com/infamousjoeg/summon-wpm/internal/config"
+)
+
+// runStatus implements `summon-wpm status [--json]`. It exits with 1 when the
+// next credential lookup has to log in again, and not when it can renew the
+// token without a login.
+func runStatus(args []string, verbose bool) {
+	flags := flag.NewFlagSet("status", flag.ExitOnError)
+	jsonOutput := flags.Bool("json", false, "Print the status as JSON")
+	flags.Parse(args)
+
+	// With an agent running, report the session it holds
+	if socket := os.Getenv(agent.SocketEnvVar); socket != "" {
+		if verbose {
+			fmt.Fprintf(os.Stderr, "Asking the agent at %s\n", socket)
+		}
+		status, err := agent.NewClient(socket).Status()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			os.Exit(1)
+		}
+		reportStatus(status, *jsonOutput)
+	}
+
+	configFile := config.GetConfigFilePath()
+	if verbose {
+		fmt.Fprintf(os.Stderr, "Reading %s\n", configFile)
+	}
+	cfg, err := config.LoadConfig(configFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "No configuration found for profile %q. Run with --config to set up\n", config.ActiveProfile())
+		} else {
+			fmt.Fprintf(os.Stderr, "Error loading config: %s\n", err)
+		}
+		os.Exit(1)
+	}
+
+	// Show the discovered tenant without caching it
+	if err := config.ResolveTenant(cfg, ""); err != nil && !*jsonOutput {
+		fmt.Fprintf(os.Stderr, "Warning: could not resolve tenant: %s\n", err)
+	}
+
+	reportStatus(auth.GetStatus(cfg, config.ActiveProfile()), *jsonOutput)
+}
+
+// reportStatus prints the status and exits with 1 when a login is needed
+func reportStatus(status *auth.Status, jsonOutput bool) {
+	if jsonOutput {
+		data, err := json.MarshalIndent(status, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(data))
+	} else {
+		printStatus(status)
+	}
+
+	if status.NeedsAuth {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// printStatus prints the status for people
+func printStatus(status *auth.Status) {
+	fmt.Printf("Profile:       %s\n", status.Profile)
+	fmt.Printf("Tenant:        %s\n", status.TenantURL)
+	fmt.Printf("Username:      %s\n", status.Username)
+	fmt.Printf("Auth mode:     %s\n", status.AuthMode)
+	if status.TokenSource != "" {
+		fmt.Printf("Token source:  %s\n", status.TokenSource)
+	}
+	if status.RefreshedAt != nil {
+		fmt.Printf("Refreshed:     %s\n", status.RefreshedAt.Local().Format(time.RFC1123))
+	}
+
+	switch {
+	case !status.HasToken:
+		fmt.Println("Token:         none")
+	case status.ExpiresAt == nil:
+		fmt.Println("Token:         present, no expiry")
+	case status.ExpiresIn > 0:
+		fmt.Printf("Token:         valid, expires in %s (%s)\n",
+			time.Duration(status.ExpiresIn)*time.Second, status.ExpiresAt.Local().Format(time.RFC1123))
+	default:
+		fmt.Printf("Token:         expired %s\n", status.ExpiresAt.Local().Format(time.RFC1123))
+	}
+	if status.HasRefreshToken {
+		fmt.Println("Refresh token: present")
+	}
+
+	if status.Identity != nil {
+		fmt.Printf("Identity:      %s (%s), tenant %s\n", status.Identity.User, status.Identity.UserUUID, status.Identity.TenantID)
+	} else if status.IdentityError != "" {
+		fmt.Printf("Identity:      unknown (%s)\n", status.IdentityError)
+	}
+
+	if status.NeedsAuth {
+		fmt.Printf("Login needed:  yes, %s\n", status.Reason)
+	} else if status.Reason != "" {
+		fmt.Printf("Login needed:  no, but %s\n", status.Reason)
+	} else {
+		fmt.Println("Login needed:  no")
+	}
+}
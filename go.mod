@@ -2,6 +2,9 @@ module github.com/infamousjoeg/summon-wpm
 
 go 1.20
 
-require golang.org/x/term v0.13.0
+require (
+	golang.org/x/term v0.13.0
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require golang.org/x/sys v0.13.0 // indirect
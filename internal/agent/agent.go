@@ -0,0 +1,80 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/infamousjoeg/summon-wpm/internal/auth"
+	"github.com/infamousjoeg/summon-wpm/internal/cache"
+)
+
+// SocketEnvVar points clients at a running agent
+const SocketEnvVar = "SUMMON_WPM_AGENT_SOCK"
+
+// Paths served by the agent
+const (
+	credentialPath  = "/v1/credential"
+	credentialsPath = "/v1/credentials"
+	appsPath        = "/v1/apps"
+	statusPath      = "/v1/status"
+)
+
+// errDenied is the error returned for requests the access policy refuses
+const errDenied = "access denied by agent policy"
+
+// CredentialResponse is the reply to a credential request. Stale is set when
+// the value was served from the cache because the tenant could not be
+// reached.
+type CredentialResponse struct {
+	Value string            `json:"value,omitempty"`
+	Stale *cache.StaleError `json:"stale,omitempty"`
+	Error string            `json:"error,omitempty"`
+}
+
+// MaxWorkers is the most credentials one batch request may fetch at once
+const MaxWorkers = 64
+
+// CredentialsRequest asks for many credentials at once. Workers must be
+// between 0, for the agent's default, and MaxWorkers.
+type CredentialsRequest struct {
+	IDs      []string `json:"ids"`
+	Workers  int      `json:"workers,omitempty"`
+	FailFast bool     `json:"fail_fast,omitempty"`
+	NoCache  bool     `json:"no_cache,omitempty"`
+}
+
+// CredentialsResult is one credential of a CredentialsResponse
+type CredentialsResult struct {
+	ID    string            `json:"id"`
+	Value string            `json:"value,omitempty"`
+	Stale *cache.StaleError `json:"stale,omitempty"`
+	Error string            `json:"error,omitempty"`
+}
+
+// CredentialsResponse is the reply to a CredentialsRequest, in request order
+type CredentialsResponse struct {
+	Results []CredentialsResult `json:"results,omitempty"`
+	Error   string              `json:"error,omitempty"`
+}
+
+// AppsResponse is the reply to an apps request
+type AppsResponse struct {
+	Apps  []auth.App `json:"apps,omitempty"`
+	Error string     `json:"error,omitempty"`
+}
+
+// StatusResponse is the reply to a status request
+type StatusResponse struct {
+	auth.Status
+	Error string `json:"error,omitempty"`
+}
+
+// DefaultSocketPath returns where the agent listens when no path is given:
+// in $XDG_RUNTIME_DIR when set, or else a per-user directory in the temp dir
+func DefaultSocketPath() string {
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		return filepath.Join(runtimeDir, "summon-wpm", "agent.sock")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("summon-wpm-%d", os.Getuid()), "agent.sock")
+}
@@ -0,0 +1,317 @@
+package agent
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/infamousjoeg/summon-wpm/internal/auth"
+)
+
+// fakeSource answers from a fixed set of credentials
+type fakeSource struct {
+	credentials map[string]string
+}
+
+func (f *fakeSource) GetCredential(id string, noCache bool) (string, error) {
+	if value, ok := f.credentials[id]; ok {
+		return value, nil
+	}
+	return "", errors.New("credential not found")
+}
+
+func (f *fakeSource) GetCredentials(request CredentialsRequest) ([]CredentialsResult, error) {
+	var results []CredentialsResult
+	for _, id := range request.IDs {
+		value, err := f.GetCredential(id, request.NoCache)
+		result := CredentialsResult{ID: id, Value: value}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func (f *fakeSource) ListApps(filter string) ([]auth.App, error) {
+	return []auth.App{{AppKey: "app-1", DisplayName: "App One"}, {AppKey: "app-2", DisplayName: "App Two"}}, nil
+}
+
+func (f *fakeSource) Status() *auth.Status {
+	return &auth.Status{Profile: "default", AuthMode: auth.AuthModeInteractive}
+}
+
+func (f *fakeSource) KeepAlive() error {
+	return nil
+}
+
+func startAgent(t *testing.T, policy *Policy) string {
+	// Unix socket paths are limited in length, so keep it short
+	dir, err := os.MkdirTemp("", "wpm")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	socketPath := filepath.Join(dir, "agent.sock")
+	server, err := Listen(socketPath, &fakeSource{credentials: map[string]string{"app-1": "secret-1", "app-2": "secret-2"}}, policy)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	go server.Serve()
+	t.Cleanup(func() { server.Close() })
+
+	return socketPath
+}
+
+func TestAgent(t *testing.T) {
+	socketPath := startAgent(t, nil)
+
+	info, err := os.Stat(socketPath)
+	if err != nil {
+		t.Fatalf("Socket not created: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Socket mode = %o, want 600", info.Mode().Perm())
+	}
+
+	client := NewClient(socketPath)
+
+	value, err := client.GetCredential("app-1", false)
+	if err != nil || value != "secret-1" {
+		t.Errorf("GetCredential = %q, %v; want secret-1", value, err)
+	}
+	if _, err := client.GetCredential("missing", false); err == nil || err.Error() != "credential not found" {
+		t.Errorf("Expected the agent's error, got %v", err)
+	}
+
+	results, err := client.GetCredentials(CredentialsRequest{IDs: []string{"app-1", "missing"}})
+	if err != nil {
+		t.Fatalf("GetCredentials failed: %v", err)
+	}
+	if len(results) != 2 || results[0].Value != "secret-1" || results[1].Error == "" {
+		t.Errorf("GetCredentials = %+v", results)
+	}
+
+	// Clients cannot ask the shared agent for unbounded concurrency
+	if _, err := client.GetCredentials(CredentialsRequest{IDs: []string{"app-1"}, Workers: MaxWorkers + 1}); err == nil {
+		t.Error("Expected an error for too many workers")
+	}
+
+	apps, err := client.ListApps("")
+	if err != nil || len(apps) != 2 {
+		t.Errorf("ListApps = %+v, %v", apps, err)
+	}
+
+	status, err := client.Status()
+	if err != nil || status.AuthMode != auth.AuthModeInteractive {
+		t.Errorf("Status = %+v, %v", status, err)
+	}
+
+	// A second agent cannot take over the socket
+	if _, err := Listen(socketPath, &fakeSource{}, nil); err == nil {
+		t.Error("Expected an error for a socket in use")
+	}
+}
+
+func TestListenReplacesStaleSocket(t *testing.T) {
+	dir, err := os.MkdirTemp("", "wpm")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A socket left behind by an agent that is gone
+	socketPath := filepath.Join(dir, "agent.sock")
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	listener.Close()
+
+	server, err := Listen(socketPath, &fakeSource{}, nil)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	server.Close()
+}
+
+func TestListenChecksSocketDir(t *testing.T) {
+	dir, err := os.MkdirTemp("", "wpm")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	t.Setenv("XDG_RUNTIME_DIR", dir)
+	socketDir := filepath.Dir(DefaultSocketPath())
+
+	// A default directory someone else could have created is refused, by the
+	// agent and by its clients
+	if err := os.Mkdir(socketDir, 0755); err != nil {
+		t.Fatalf("Failed to create socket dir: %v", err)
+	}
+	if _, err := Listen(DefaultSocketPath(), &fakeSource{}, nil); err == nil || !strings.Contains(err.Error(), "mode 755") {
+		t.Errorf("Expected a mode error, got %v", err)
+	}
+	if _, err := NewClient(DefaultSocketPath()).GetCredential("app-1", false); err == nil || !strings.Contains(err.Error(), "mode 755") {
+		t.Errorf("Expected the client to refuse the socket, got %v", err)
+	}
+
+	// So is a symlink, even to a private directory
+	os.Remove(socketDir)
+	target := filepath.Join(dir, "target")
+	if err := os.Mkdir(target, 0700); err != nil {
+		t.Fatalf("Failed to create target dir: %v", err)
+	}
+	if err := os.Symlink(target, socketDir); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+	if _, err := Listen(DefaultSocketPath(), &fakeSource{}, nil); err == nil || !strings.Contains(err.Error(), "not a directory") {
+		t.Errorf("Expected a symlink error, got %v", err)
+	}
+
+	// A directory chosen with --socket only has to keep other users out
+	shared := filepath.Join(dir, "shared")
+	if err := os.Mkdir(shared, 0777); err != nil {
+		t.Fatalf("Failed to create shared dir: %v", err)
+	}
+	os.Chmod(shared, 0777)
+	if _, err := Listen(filepath.Join(shared, "agent.sock"), &fakeSource{}, nil); err == nil || !strings.Contains(err.Error(), "writable by other users") {
+		t.Errorf("Expected a writable directory error, got %v", err)
+	}
+
+	// The directory the agent creates itself passes
+	os.Remove(socketDir)
+	server, err := Listen(DefaultSocketPath(), &fakeSource{}, nil)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	server.Close()
+}
+
+func TestListenKeepsOtherFiles(t *testing.T) {
+	dir, err := os.MkdirTemp("", "wpm")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A regular file given as the socket path by mistake
+	socketPath := filepath.Join(dir, "notes.txt")
+	if err := os.WriteFile(socketPath, []byte("keep me"), 0600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	if _, err := Listen(socketPath, &fakeSource{}, nil); err == nil || !strings.Contains(err.Error(), "not a socket") {
+		t.Errorf("Expected a not a socket error, got %v", err)
+	}
+	if data, err := os.ReadFile(socketPath); err != nil || string(data) != "keep me" {
+		t.Errorf("File was changed: %q, %v", data, err)
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	dir, err := os.MkdirTemp("", "wpm")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	policyFile := filepath.Join(dir, "policy.json")
+	if err := os.WriteFile(policyFile, []byte(`{"rules": [
+		{"uid": 1001, "apps": ["build-*"]},
+		{"gid": 2000, "exe": "/usr/bin/terraform", "apps": ["cloud-admin"]}
+	]}`), 0600); err != nil {
+		t.Fatalf("Failed to write policy: %v", err)
+	}
+
+	policy, err := LoadPolicy(policyFile)
+	if err != nil {
+		t.Fatalf("LoadPolicy failed: %v", err)
+	}
+
+	tests := []struct {
+		peer    Peer
+		appID   string
+		allowed bool
+	}{
+		{Peer{UID: 1001}, "build-db", true},
+		{Peer{UID: 1001}, "cloud-admin", false},
+		{Peer{UID: 1002}, "build-db", false},
+		{Peer{UID: 1002, GID: 2000, Exe: "/usr/bin/terraform"}, "cloud-admin", true},
+		{Peer{UID: 1002, GID: 2000, Exe: "/usr/bin/bash"}, "cloud-admin", false},
+		{Peer{UID: 1002, GID: 2000}, "cloud-admin", false},
+	}
+	for _, tt := range tests {
+		if allowed := policy.Allows(&tt.peer, tt.appID); allowed != tt.allowed {
+			t.Errorf("Allows(%s, %s) = %v, want %v", tt.peer.String(), tt.appID, allowed, tt.allowed)
+		}
+	}
+	if policy.Known(&Peer{UID: 1002}) {
+		t.Error("Known should be false for a peer matching no rule")
+	}
+
+	for _, invalid := range []string{
+		`{"rules": [{"apps": ["*"]}]}`,
+		`{"rules": [{"uid": 1001}]}`,
+		`{"rules": [{"exe": "/usr/bin/terraform", "apps": ["*"]}]}`,
+		`{"rules": [{"uid": 1001, "apps": ["[" ]}]}`,
+	} {
+		if err := os.WriteFile(policyFile, []byte(invalid), 0600); err != nil {
+			t.Fatalf("Failed to write policy: %v", err)
+		}
+		if _, err := LoadPolicy(policyFile); err == nil {
+			t.Errorf("Expected an error for policy %s", invalid)
+		}
+	}
+}
+
+func TestAgentPolicy(t *testing.T) {
+	if !peerCredentialsSupported {
+		t.Skip("peer credentials are only supported on Linux")
+	}
+
+	uid := uint32(os.Getuid())
+	policy := &Policy{Rules: []PolicyRule{{UID: &uid, Apps: []string{"app-1"}}}}
+
+	// The default socket directory is private, so a policy needs --socket
+	if _, err := Listen(DefaultSocketPath(), &fakeSource{}, policy); err == nil || !strings.Contains(err.Error(), "--socket") {
+		t.Errorf("Expected a --socket error, got %v", err)
+	}
+
+	socketPath := startAgent(t, policy)
+
+	info, err := os.Stat(socketPath)
+	if err != nil {
+		t.Fatalf("Socket not created: %v", err)
+	}
+	if info.Mode().Perm() != 0666 {
+		t.Errorf("Socket mode = %o, want 666", info.Mode().Perm())
+	}
+
+	client := NewClient(socketPath)
+
+	if value, err := client.GetCredential("app-1", false); err != nil || value != "secret-1" {
+		t.Errorf("GetCredential = %q, %v", value, err)
+	}
+	if _, err := client.GetCredential("app-2", false); err == nil || err.Error() != errDenied {
+		t.Errorf("Expected a denial, got %v", err)
+	}
+
+	results, err := client.GetCredentials(CredentialsRequest{IDs: []string{"app-2", "app-1"}})
+	if err != nil {
+		t.Fatalf("GetCredentials failed: %v", err)
+	}
+	if len(results) != 2 || results[0].Error != errDenied || results[1].Value != "secret-1" {
+		t.Errorf("GetCredentials = %+v", results)
+	}
+
+	apps, err := client.ListApps("")
+	if err != nil || len(apps) != 1 || apps[0].AppKey != "app-1" {
+		t.Errorf("ListApps = %+v, %v", apps, err)
+	}
+}
@@ -0,0 +1,130 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"time"
+
+	"github.com/infamousjoeg/summon-wpm/internal/auth"
+)
+
+// clientTimeout allows for the agent logging in again to answer a request
+const clientTimeout = 5 * time.Minute
+
+// Client talks to an agent over its Unix socket
+type Client struct {
+	http *http.Client
+}
+
+// NewClient returns a client for the agent listening on socketPath. The
+// socket's directory is checked as the agent checks it before each
+// connection, so that a socket planted by another user is not trusted.
+func NewClient(socketPath string) *Client {
+	dir := filepath.Dir(socketPath)
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			if err := checkSocketDir(dir, privateSocketDir(dir)); err != nil {
+				return nil, err
+			}
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socketPath)
+		},
+	}
+	return &Client{http: &http.Client{Transport: transport, Timeout: clientTimeout}}
+}
+
+// GetCredential asks the agent for one credential, bypassing its credential
+// cache when noCache is set. A value the agent served from its cache because
+// the tenant could not be reached comes with a *cache.StaleError.
+func (c *Client) GetCredential(id string, noCache bool) (string, error) {
+	path := credentialPath + "?id=" + url.QueryEscape(id)
+	if noCache {
+		path += "&no_cache=true"
+	}
+
+	var response CredentialResponse
+	if err := c.do("GET", path, nil, &response); err != nil {
+		return "", err
+	}
+	if response.Error != "" {
+		return "", errors.New(response.Error)
+	}
+	if response.Stale != nil {
+		return response.Value, response.Stale
+	}
+	return response.Value, nil
+}
+
+// GetCredentials asks the agent for many credentials at once
+func (c *Client) GetCredentials(request CredentialsRequest) ([]CredentialsResult, error) {
+	var response CredentialsResponse
+	if err := c.do("POST", credentialsPath, request, &response); err != nil {
+		return nil, err
+	}
+	if response.Error != "" {
+		return nil, errors.New(response.Error)
+	}
+	return response.Results, nil
+}
+
+// ListApps asks the agent for the applications matching filter
+func (c *Client) ListApps(filter string) ([]auth.App, error) {
+	var response AppsResponse
+	if err := c.do("GET", appsPath+"?filter="+url.QueryEscape(filter), nil, &response); err != nil {
+		return nil, err
+	}
+	if response.Error != "" {
+		return nil, errors.New(response.Error)
+	}
+	return response.Apps, nil
+}
+
+// Status asks the agent for the status of the session it holds
+func (c *Client) Status() (*auth.Status, error) {
+	var response StatusResponse
+	if err := c.do("GET", statusPath, nil, &response); err != nil {
+		return nil, err
+	}
+	if response.Error != "" {
+		return nil, errors.New(response.Error)
+	}
+	return &response.Status, nil
+}
+
+// do sends a request to the agent and decodes the JSON reply into result.
+// Error replies carry their message in an "error" field.
+func (c *Client) do(method, path string, body interface{}, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("error marshaling agent request: %s", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, "http://agent"+path, reader)
+	if err != nil {
+		return fmt.Errorf("error creating agent request: %s", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("agent request failed: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("error parsing agent response (status %s): %s", resp.Status, err)
+	}
+	return nil
+}
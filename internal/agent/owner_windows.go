@@ -0,0 +1,10 @@
+//go:build windows
+
+package agent
+
+import "os"
+
+// fileOwner is not available on Windows, where files have no uid
+func fileOwner(info os.FileInfo) (int, bool) {
+	return 0, false
+}
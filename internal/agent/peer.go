@@ -0,0 +1,37 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+)
+
+// Peer identifies the process on the other end of the socket
+type Peer struct {
+	UID uint32
+	GID uint32
+	PID int32
+	// Exe is the path of the peer's executable, when it could be read
+	Exe string
+}
+
+func (p *Peer) String() string {
+	exe := p.Exe
+	if exe == "" {
+		exe = "unknown"
+	}
+	return fmt.Sprintf("uid=%d gid=%d pid=%d exe=%s", p.UID, p.GID, p.PID, exe)
+}
+
+// peerKey is the context key for the connection's peer
+type peerKey struct{}
+
+// withPeer returns a context carrying the connection's peer
+func withPeer(ctx context.Context, peer *Peer) context.Context {
+	return context.WithValue(ctx, peerKey{}, peer)
+}
+
+// peerFrom returns the peer stored by withPeer, or nil
+func peerFrom(ctx context.Context) *Peer {
+	peer, _ := ctx.Value(peerKey{}).(*Peer)
+	return peer
+}
@@ -0,0 +1,45 @@
+//go:build linux
+
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// peerCredentialsSupported reports whether peer credentials can be read
+const peerCredentialsSupported = true
+
+// peerCredentials reads the peer's identity from the socket with SO_PEERCRED
+func peerCredentials(conn net.Conn) (*Peer, error) {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return nil, errors.New("not a Unix socket connection")
+	}
+
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var cred *syscall.Ucred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); err != nil {
+		return nil, err
+	}
+	if credErr != nil {
+		return nil, fmt.Errorf("error reading peer credentials: %s", credErr)
+	}
+
+	peer := &Peer{UID: cred.Uid, GID: cred.Gid, PID: cred.Pid}
+	// The executable can only be read for our own processes unless we are privileged
+	if exe, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", cred.Pid)); err == nil {
+		peer.Exe = exe
+	}
+	return peer, nil
+}
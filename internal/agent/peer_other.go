@@ -0,0 +1,16 @@
+//go:build !linux
+
+package agent
+
+import (
+	"errors"
+	"net"
+)
+
+// peerCredentialsSupported reports whether peer credentials can be read
+const peerCredentialsSupported = false
+
+// peerCredentials is only implemented on Linux
+func peerCredentials(conn net.Conn) (*Peer, error) {
+	return nil, errors.New("peer credentials are only supported on Linux")
+}
@@ -0,0 +1,150 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path"
+	"strconv"
+)
+
+// Policy maps the identities of socket clients to the app IDs they may
+// fetch. A request is allowed when any rule matching the client lists a
+// pattern matching the app ID; everything else is denied.
+type Policy struct {
+	Rules []PolicyRule `json:"rules"`
+}
+
+// PolicyRule grants access to apps for the clients matching every identity
+// field that is set. User and group names are resolved when the policy is
+// loaded.
+type PolicyRule struct {
+	UID *uint32 `json:"uid,omitempty"`
+	// GID and Group match the client's primary group only, not its
+	// supplementary groups
+	GID   *uint32 `json:"gid,omitempty"`
+	User  string  `json:"user,omitempty"`
+	Group string  `json:"group,omitempty"`
+	// Exe is the client's executable path; glob patterns are allowed. It is
+	// read from /proc when the client connects, and a client can change it
+	// afterwards with exec or by exiting, so it only narrows a rule that also
+	// names a uid, gid, user or group.
+	Exe string `json:"exe,omitempty"`
+	// Apps are app ID glob patterns, e.g. "build-*"
+	Apps []string `json:"apps"`
+}
+
+// LoadPolicy reads and validates a policy file
+func LoadPolicy(policyFile string) (*Policy, error) {
+	data, err := os.ReadFile(policyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var policy Policy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, fmt.Errorf("invalid policy file format: %s", err)
+	}
+
+	for i := range policy.Rules {
+		if err := policy.Rules[i].resolve(); err != nil {
+			return nil, fmt.Errorf("rule %d: %s", i+1, err)
+		}
+	}
+	return &policy, nil
+}
+
+// resolve checks the rule and turns user and group names into IDs
+func (r *PolicyRule) resolve() error {
+	if r.UID == nil && r.GID == nil && r.User == "" && r.Group == "" {
+		// exe alone can be spoofed, so it never identifies a client by itself
+		return errors.New("needs at least one of uid, gid, user or group")
+	}
+	if len(r.Apps) == 0 {
+		return errors.New("grants no apps")
+	}
+	for _, pattern := range append([]string{r.Exe}, r.Apps...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q", pattern)
+		}
+	}
+
+	if r.User != "" {
+		u, err := user.Lookup(r.User)
+		if err != nil {
+			return err
+		}
+		uid, err := strconv.ParseUint(u.Uid, 10, 32)
+		if err != nil {
+			return fmt.Errorf("user %s has no numeric uid", r.User)
+		}
+		if r.UID != nil && *r.UID != uint32(uid) {
+			return fmt.Errorf("user %s does not have uid %d", r.User, *r.UID)
+		}
+		id := uint32(uid)
+		r.UID = &id
+	}
+
+	if r.Group != "" {
+		g, err := user.LookupGroup(r.Group)
+		if err != nil {
+			return err
+		}
+		gid, err := strconv.ParseUint(g.Gid, 10, 32)
+		if err != nil {
+			return fmt.Errorf("group %s has no numeric gid", r.Group)
+		}
+		if r.GID != nil && *r.GID != uint32(gid) {
+			return fmt.Errorf("group %s does not have gid %d", r.Group, *r.GID)
+		}
+		id := uint32(gid)
+		r.GID = &id
+	}
+	return nil
+}
+
+// matches reports whether the rule applies to the peer
+func (r *PolicyRule) matches(peer *Peer) bool {
+	if r.UID != nil && *r.UID != peer.UID {
+		return false
+	}
+	if r.GID != nil && *r.GID != peer.GID {
+		return false
+	}
+	if r.Exe != "" {
+		if peer.Exe == "" {
+			return false
+		}
+		if ok, _ := path.Match(r.Exe, peer.Exe); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Known reports whether any rule applies to the peer
+func (p *Policy) Known(peer *Peer) bool {
+	for i := range p.Rules {
+		if p.Rules[i].matches(peer) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allows reports whether the peer may fetch the app
+func (p *Policy) Allows(peer *Peer, appID string) bool {
+	for i := range p.Rules {
+		if !p.Rules[i].matches(peer) {
+			continue
+		}
+		for _, pattern := range p.Rules[i].Apps {
+			if ok, _ := path.Match(pattern, appID); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,303 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/infamousjoeg/summon-wpm/internal/auth"
+	"github.com/infamousjoeg/summon-wpm/internal/cache"
+	"github.com/infamousjoeg/summon-wpm/internal/selector"
+)
+
+// Source answers the agent's requests, normally a provider holding the
+// session in memory
+type Source interface {
+	GetCredential(id string, noCache bool) (string, error)
+	GetCredentials(request CredentialsRequest) ([]CredentialsResult, error)
+	ListApps(filter string) ([]auth.App, error)
+	Status() *auth.Status
+	// KeepAlive renews the session when it is close to expiring
+	KeepAlive() error
+}
+
+// keepAliveInterval is how often the session is checked for renewal
+var keepAliveInterval = 30 * time.Second
+
+// Server answers credential requests on a Unix socket
+type Server struct {
+	source   Source
+	listener net.Listener
+	path     string
+	policy   *Policy
+	logf     func(format string, args ...interface{})
+}
+
+// Listen creates the socket and returns a server for it. A stale socket left
+// by an agent that is gone is replaced, and a socket directory that another
+// user could have planted is refused; see checkSocketDir. Without a policy
+// the socket is only usable by the current user. With one the socket is
+// world-connectable, so the policy is the only control: each request is
+// checked against it using the caller's peer credentials.
+func Listen(socketPath string, source Source, policy *Policy) (*Server, error) {
+	dir := filepath.Dir(socketPath)
+	socketMode, dirMode := os.FileMode(0600), os.FileMode(0700)
+	if policy != nil {
+		if !peerCredentialsSupported {
+			return nil, errors.New("access policies need peer credentials, which are only supported on Linux")
+		}
+		// The agent's own directory stays private, so other users could
+		// never reach the socket in it
+		if privateSocketDir(dir) {
+			return nil, errors.New("an agent with a policy needs --socket in a directory its clients can reach")
+		}
+		socketMode, dirMode = 0666, 0755
+	}
+
+	if err := os.MkdirAll(dir, dirMode); err != nil {
+		return nil, fmt.Errorf("error creating socket directory: %s", err)
+	}
+	if err := checkSocketDir(dir, privateSocketDir(dir)); err != nil {
+		return nil, err
+	}
+
+	if info, err := os.Lstat(socketPath); err == nil {
+		// Only ever remove a socket, never a file the path was mistyped to
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", socketPath)
+		}
+		if conn, err := net.Dial("unix", socketPath); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("an agent is already listening on %s", socketPath)
+		}
+		os.Remove(socketPath)
+	}
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("error listening on %s: %s", socketPath, err)
+	}
+	if err := os.Chmod(socketPath, socketMode); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("error securing socket: %s", err)
+	}
+
+	return &Server{
+		source:   source,
+		listener: listener,
+		path:     socketPath,
+		policy:   policy,
+		logf: func(format string, args ...interface{}) {
+			fmt.Fprintf(os.Stderr, format+"\n", args...)
+		},
+	}, nil
+}
+
+// Serve answers requests until Close is called, renewing the session in
+// the background
+func (s *Server) Serve() error {
+	stop := make(chan struct{})
+	defer close(stop)
+	go s.keepAlive(stop)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(credentialPath, s.handleCredential)
+	mux.HandleFunc(credentialsPath, s.handleCredentials)
+	mux.HandleFunc(appsPath, s.handleApps)
+	mux.HandleFunc(statusPath, s.handleStatus)
+
+	httpServer := &http.Server{Handler: mux, ConnContext: s.connContext}
+	err := httpServer.Serve(s.listener)
+	if errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+// Close stops the server and removes the socket
+func (s *Server) Close() error {
+	err := s.listener.Close()
+	os.Remove(s.path)
+	return err
+}
+
+// connContext identifies the client when a policy is enforced
+func (s *Server) connContext(ctx context.Context, conn net.Conn) context.Context {
+	if s.policy == nil {
+		return ctx
+	}
+	peer, err := peerCredentials(conn)
+	if err != nil {
+		s.logf("Warning: could not identify client: %s", err)
+		return ctx
+	}
+	return withPeer(ctx, peer)
+}
+
+// allowed checks the policy for a credential request, logging denials
+func (s *Server) allowed(r *http.Request, id string) bool {
+	if s.policy == nil {
+		return true
+	}
+	appID, _ := selector.Parse(id)
+	peer := peerFrom(r.Context())
+	if peer != nil && s.policy.Allows(peer, appID) {
+		return true
+	}
+	s.logf("Denied %s to %s", appID, describePeer(peer))
+	return false
+}
+
+// known checks whether any policy rule applies to the client, logging denials
+func (s *Server) known(r *http.Request) bool {
+	if s.policy == nil {
+		return true
+	}
+	peer := peerFrom(r.Context())
+	if peer != nil && s.policy.Known(peer) {
+		return true
+	}
+	s.logf("Denied %s to %s", r.URL.Path, describePeer(peer))
+	return false
+}
+
+// describePeer names the client for log messages
+func describePeer(peer *Peer) string {
+	if peer == nil {
+		return "unidentified client"
+	}
+	return peer.String()
+}
+
+// keepAlive renews the session until stop is closed
+func (s *Server) keepAlive(stop chan struct{}) {
+	ticker := time.NewTicker(keepAliveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := s.source.KeepAlive(); err != nil {
+				s.logf("Warning: could not renew the session: %s", err)
+			}
+		}
+	}
+}
+
+func (s *Server) handleCredential(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		writeJSON(w, http.StatusBadRequest, CredentialResponse{Error: "missing id"})
+		return
+	}
+	if !s.allowed(r, id) {
+		writeJSON(w, http.StatusForbidden, CredentialResponse{Error: errDenied})
+		return
+	}
+
+	noCache := r.URL.Query().Get("no_cache") == "true"
+	value, err := s.source.GetCredential(id, noCache)
+	var stale *cache.StaleError
+	if errors.As(err, &stale) {
+		writeJSON(w, http.StatusOK, CredentialResponse{Value: value, Stale: stale})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusBadGateway, CredentialResponse{Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, CredentialResponse{Value: value})
+}
+
+func (s *Server) handleCredentials(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, CredentialsResponse{Error: "use POST"})
+		return
+	}
+
+	var request CredentialsRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeJSON(w, http.StatusBadRequest, CredentialsResponse{Error: fmt.Sprintf("invalid request: %s", err)})
+		return
+	}
+	if request.Workers < 0 || request.Workers > MaxWorkers {
+		writeJSON(w, http.StatusBadRequest, CredentialsResponse{Error: fmt.Sprintf("invalid request: workers must be between 0 and %d", MaxWorkers)})
+		return
+	}
+
+	// Denied IDs are answered here and the rest fetched as one batch
+	results := make([]CredentialsResult, len(request.IDs))
+	var allowedIDs []string
+	var positions []int
+	for i, id := range request.IDs {
+		results[i].ID = id
+		if !s.allowed(r, id) {
+			results[i].Error = errDenied
+			continue
+		}
+		allowedIDs = append(allowedIDs, id)
+		positions = append(positions, i)
+	}
+
+	if len(allowedIDs) > 0 {
+		request.IDs = allowedIDs
+		fetched, err := s.source.GetCredentials(request)
+		if err != nil {
+			writeJSON(w, http.StatusBadGateway, CredentialsResponse{Error: err.Error()})
+			return
+		}
+		for i, result := range fetched {
+			results[positions[i]] = result
+		}
+	}
+	writeJSON(w, http.StatusOK, CredentialsResponse{Results: results})
+}
+
+func (s *Server) handleApps(w http.ResponseWriter, r *http.Request) {
+	if !s.known(r) {
+		writeJSON(w, http.StatusForbidden, AppsResponse{Error: errDenied})
+		return
+	}
+
+	apps, err := s.source.ListApps(r.URL.Query().Get("filter"))
+	if err != nil {
+		writeJSON(w, http.StatusBadGateway, AppsResponse{Error: err.Error()})
+		return
+	}
+
+	// Only list the apps the client may fetch
+	if s.policy != nil {
+		peer := peerFrom(r.Context())
+		visible := apps[:0]
+		for _, app := range apps {
+			if s.policy.Allows(peer, app.AppKey) {
+				visible = append(visible, app)
+			}
+		}
+		apps = visible
+	}
+	writeJSON(w, http.StatusOK, AppsResponse{Apps: apps})
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if !s.known(r) {
+		writeJSON(w, http.StatusForbidden, StatusResponse{Error: errDenied})
+		return
+	}
+	writeJSON(w, http.StatusOK, StatusResponse{Status: *s.source.Status()})
+}
+
+// writeJSON writes a JSON reply
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,43 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// privateSocketDir reports whether dir is the agent's own directory from
+// DefaultSocketPath, as opposed to one chosen with --socket
+func privateSocketDir(dir string) bool {
+	return dir == filepath.Dir(DefaultSocketPath())
+}
+
+// checkSocketDir refuses a socket directory another user could have created
+// or could swap the socket in. The agent's own directory must be a real
+// directory of the current user with mode 0700; a directory chosen with
+// --socket must not be writable by other users unless it is sticky, like
+// /tmp.
+func checkSocketDir(dir string, private bool) error {
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return fmt.Errorf("error checking socket directory: %s", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("socket directory %s is not a directory", dir)
+	}
+
+	if private {
+		if owner, ok := fileOwner(info); ok && owner != os.Getuid() {
+			return fmt.Errorf("socket directory %s is owned by uid %d, not by you", dir, owner)
+		}
+		if info.Mode().Perm() != 0700 {
+			return fmt.Errorf("socket directory %s has mode %o, want 700", dir, info.Mode().Perm())
+		}
+		return nil
+	}
+
+	if info.Mode().Perm()&0022 != 0 && info.Mode()&os.ModeSticky == 0 {
+		return fmt.Errorf("socket directory %s is writable by other users", dir)
+	}
+	return nil
+}
@@ -10,35 +10,19 @@ import (
 	"github.com/infamousjoeg/summon-wpm/internal/config"
 )
 
-// MakeRequest makes a non-authenticated request to the CyberArk Identity API
-func MakeRequest(cfg *config.Config, method, endpoint string, body io.Reader) ([]byte, error) {
-	// Ensure baseURL doesn't end with slash
-	baseURL := strings.TrimRight(cfg.TenantURL, "/")
-
-	// Create request
-	req, err := http.NewRequest(method, baseURL+endpoint, body)
-	if err != nil {
-		return nil, err
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Accept", "application/json")
-
-	// Make request
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
+// StatusError is returned when the tenant answers with an unexpected HTTP status
+type StatusError struct {
+	StatusCode int
+	Status     string
+}
 
-	// Check response
-	if resp.StatusCode != http.StatusOK {
-		return nil, errors.New("request failed with status: " + resp.Status)
-	}
+func (e *StatusError) Error() string {
+	return "request failed with status: " + e.Status
+}
 
-	// Read response body
-	return io.ReadAll(resp.Body)
+// MakeRequest makes a non-authenticated request to the CyberArk Identity API
+func MakeRequest(cfg *config.Config, method, endpoint string, body io.Reader) ([]byte, error) {
+	return NewSession(cfg).MakeRequest(method, endpoint, body)
 }
 
 // MakeAuthenticatedRequest makes an authenticated request to the CyberArk Identity API
@@ -69,7 +53,7 @@ func MakeAuthenticatedRequest(cfg *config.Config, method, endpoint string, body
 		if resp.StatusCode == http.StatusUnauthorized {
 			return nil, errors.New("authentication failed: token expired or invalid")
 		}
-		return nil, errors.New("request failed with status: " + resp.Status)
+		return nil, &StatusError{StatusCode: resp.StatusCode, Status: resp.Status}
 	}
 
 	// Read response body
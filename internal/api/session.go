@@ -0,0 +1,169 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/infamousjoeg/summon-wpm/internal/config"
+)
+
+// Session is a cookie-aware client for a multi-request login. Cookies set by
+// the tenant are sent back on later requests, and persistent ones such as the
+// trusted-device cookie can be stored in the config for the next login.
+type Session struct {
+	cfg      *config.Config
+	client   *http.Client
+	baseURL  string
+	received []config.DeviceCookie
+}
+
+// NewSession returns a session that starts with the cookies stored in the config
+func NewSession(cfg *config.Config) *Session {
+	jar, _ := cookiejar.New(nil)
+
+	now := time.Now().Unix()
+	for _, stored := range cfg.DeviceCookies {
+		if stored.Expires <= now {
+			continue
+		}
+		cookie := &http.Cookie{
+			Name:     stored.Name,
+			Value:    stored.Value,
+			Path:     stored.Path,
+			Secure:   stored.Secure,
+			HttpOnly: stored.HttpOnly,
+			Expires:  time.Unix(stored.Expires, 0),
+		}
+		if !stored.HostOnly {
+			cookie.Domain = stored.Domain
+		}
+		jar.SetCookies(cookieURL(stored), []*http.Cookie{cookie})
+	}
+
+	return &Session{
+		cfg:    cfg,
+		client: &http.Client{Timeout: 30 * time.Second, Jar: jar},
+	}
+}
+
+// cookieURL returns a URL the stored cookie applies to
+func cookieURL(stored config.DeviceCookie) *url.URL {
+	scheme := "http"
+	if stored.Secure {
+		scheme = "https"
+	}
+	path := stored.Path
+	if path == "" {
+		path = "/"
+	}
+	return &url.URL{Scheme: scheme, Host: strings.TrimPrefix(stored.Domain, "."), Path: path}
+}
+
+// BaseURL returns the URL the session sends requests to: the pod it was
+// redirected to, or else the configured tenant URL
+func (s *Session) BaseURL() string {
+	if s.baseURL != "" {
+		return s.baseURL
+	}
+	return strings.TrimRight(s.cfg.TenantURL, "/")
+}
+
+// SetBaseURL sends the rest of the session's requests to another pod without
+// changing the configured tenant URL
+func (s *Session) SetBaseURL(baseURL string) {
+	s.baseURL = strings.TrimRight(baseURL, "/")
+}
+
+// MakeRequest makes a non-authenticated request to the tenant within the session
+func (s *Session) MakeRequest(method, endpoint string, body io.Reader) ([]byte, error) {
+	baseURL := s.BaseURL()
+
+	// Create request
+	req, err := http.NewRequest(method, baseURL+endpoint, body)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	// Make request
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	s.record(req.URL, resp.Cookies())
+
+	// Check response
+	if resp.StatusCode != http.StatusOK {
+		return nil, &StatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+
+	// Read response body
+	return io.ReadAll(resp.Body)
+}
+
+// record keeps the persistent cookies set by a response. Session cookies
+// without an expiry end with the login and are not kept.
+func (s *Session) record(requestURL *url.URL, cookies []*http.Cookie) {
+	for _, cookie := range cookies {
+		stored := config.DeviceCookie{
+			Name:     cookie.Name,
+			Value:    cookie.Value,
+			Domain:   cookie.Domain,
+			Path:     cookie.Path,
+			Secure:   cookie.Secure,
+			HttpOnly: cookie.HttpOnly,
+		}
+		if stored.Domain == "" {
+			stored.Domain = requestURL.Hostname()
+			stored.HostOnly = true
+		}
+
+		switch {
+		case cookie.MaxAge < 0:
+			stored.Expires = 0 // deleted by the tenant
+		case cookie.MaxAge > 0:
+			stored.Expires = time.Now().Add(time.Duration(cookie.MaxAge) * time.Second).Unix()
+		case !cookie.Expires.IsZero():
+			stored.Expires = cookie.Expires.Unix()
+		default:
+			continue
+		}
+		s.received = append(s.received, stored)
+	}
+}
+
+// SaveCookies merges the persistent cookies received in this session into
+// the config's DeviceCookies, dropping deleted and expired ones. The caller
+// saves the config.
+func (s *Session) SaveCookies() {
+	now := time.Now().Unix()
+
+	var cookies []config.DeviceCookie
+	for _, stored := range append(s.cfg.DeviceCookies, s.received...) {
+		replaced := false
+		for i := range cookies {
+			if cookies[i].Name == stored.Name && cookies[i].Domain == stored.Domain && cookies[i].Path == stored.Path {
+				cookies[i] = stored
+				replaced = true
+			}
+		}
+		if !replaced {
+			cookies = append(cookies, stored)
+		}
+	}
+
+	s.cfg.DeviceCookies = nil
+	for _, stored := range cookies {
+		if stored.Expires > now {
+			s.cfg.DeviceCookies = append(s.cfg.DeviceCookies, stored)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/infamousjoeg/summon-wpm/internal/api"
+	"github.com/infamousjoeg/summon-wpm/internal/config"
+)
+
+// appsPageSize is how many applications are requested per page
+const appsPageSize = 100
+
+// maxAppsPages bounds the pages fetched in case the tenant keeps paging
+const maxAppsPages = 100
+
+// App is an application in the user portal. AppKey is the app_id passed to
+// GetAppCredentials.
+type App struct {
+	AppKey        string `json:"AppKey"`
+	DisplayName   string `json:"DisplayName"`
+	Name          string `json:"Name,omitempty"`
+	AppType       string `json:"AppType"`
+	WebAppType    string `json:"WebAppType,omitempty"`
+	CredentialSet bool   `json:"PasswordIsSet"`
+}
+
+// Type returns the application type shown to the user
+func (a App) Type() string {
+	if a.WebAppType != "" {
+		return a.WebAppType
+	}
+	return a.AppType
+}
+
+// Title returns the display name, falling back on the application name
+func (a App) Title() string {
+	if a.DisplayName != "" {
+		return a.DisplayName
+	}
+	return a.Name
+}
+
+// Matches reports whether the filter occurs in the app key, name or type,
+// ignoring case. An empty filter matches every application.
+func (a App) Matches(filter string) bool {
+	filter = strings.ToLower(filter)
+	for _, field := range []string{a.AppKey, a.DisplayName, a.Name, a.Type()} {
+		if strings.Contains(strings.ToLower(field), filter) {
+			return true
+		}
+	}
+	return false
+}
+
+// appsRequest pages through the user portal applications
+type appsRequest struct {
+	Args appsPageArgs `json:"Args"`
+}
+
+type appsPageArgs struct {
+	PageNumber int `json:"PageNumber"`
+	PageSize   int `json:"PageSize"`
+}
+
+// appsResponse represents one page of user portal applications
+type appsResponse struct {
+	Success bool `json:"success"`
+	Result  struct {
+		Apps      []App `json:"Apps"`
+		FullCount int   `json:"FullCount"`
+	} `json:"Result"`
+	Message string `json:"Message"`
+}
+
+// ListApps returns the applications available to the current identity whose
+// key, name or type matches filter, fetching every page
+func ListApps(cfg *config.Config, filter string) ([]App, error) {
+	var apps []App
+	seen := 0
+
+	for page := 1; page <= maxAppsPages; page++ {
+		body, err := json.Marshal(appsRequest{Args: appsPageArgs{PageNumber: page, PageSize: appsPageSize}})
+		if err != nil {
+			return nil, fmt.Errorf("error marshaling apps request: %s", err)
+		}
+
+		appsResp, err := api.MakeAuthenticatedRequest(cfg, "POST", GetUPDataEndpoint, bytes.NewBuffer(body))
+		if err != nil {
+			return nil, fmt.Errorf("apps request failed: %w", err)
+		}
+
+		var response appsResponse
+		if err := json.Unmarshal(appsResp, &response); err != nil {
+			return nil, fmt.Errorf("error parsing apps response: %s", err)
+		}
+		if !response.Success {
+			return nil, fmt.Errorf("listing apps failed: %s", response.Message)
+		}
+
+		for _, app := range response.Result.Apps {
+			if app.Matches(filter) {
+				apps = append(apps, app)
+			}
+		}
+
+		// The last page is short, or completes the count the tenant reported
+		seen += len(response.Result.Apps)
+		if len(response.Result.Apps) < appsPageSize ||
+			(response.Result.FullCount > 0 && seen >= response.Result.FullCount) {
+			return apps, nil
+		}
+	}
+
+	return nil, errors.New("too many pages of apps")
+}
@@ -2,14 +2,31 @@ package auth
 
 import (
 	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
 	"io"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/infamousjoeg/summon-wpm/internal/api"
 	"github.com/infamousjoeg/summon-wpm/internal/config"
 )
 
@@ -195,3 +212,1158 @@ func TestGetAppCredentials(t *testing.T) {
 		t.Errorf("Expected password to be 'app-password', got %s", password)
 	}
 }
+
+func TestStartAuthenticationFollowsPodRedirect(t *testing.T) {
+	// The user's pod returns the challenges
+	pod := setupMockServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != StartAuthEndpoint {
+			t.Errorf("Expected request to %s, got %s", StartAuthEndpoint, r.URL.Path)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{
+			"success": true,
+			"SessionId": "pod-session",
+			"Challenges": [{"Mechanisms": [{"MechanismId": "up", "Name": "UP"}]}]
+		}`))
+	})
+
+	// The configured tenant redirects to the user's pod
+	tenant := setupMockServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{
+			"success": true,
+			"Result": {"PodFqdn": "` + pod.URL + `"}
+		}`))
+	})
+
+	cfg := &config.Config{
+		TenantURL: tenant.URL,
+		Username:  "user@example.com",
+	}
+
+	client := api.NewSession(cfg)
+	startAuthResponse, err := startAuthentication(client, cfg)
+	if err != nil {
+		t.Fatalf("startAuthentication failed: %v", err)
+	}
+
+	if startAuthResponse.SessionID != "pod-session" {
+		t.Errorf("Expected SessionID 'pod-session', got %s", startAuthResponse.SessionID)
+	}
+	if len(startAuthResponse.Challenges) != 1 {
+		t.Errorf("Expected 1 challenge, got %d", len(startAuthResponse.Challenges))
+	}
+	if client.BaseURL() != pod.URL {
+		t.Errorf("Expected the session to continue on %s, got %s", pod.URL, client.BaseURL())
+	}
+
+	// The pod is only saved as the tenant URL when the profile asks for it
+	if err := finishLogin(client, cfg, "", AuthModeInteractive, "token"); err != nil {
+		t.Fatalf("finishLogin failed: %v", err)
+	}
+	if cfg.TenantURL != tenant.URL {
+		t.Errorf("Expected TenantURL to stay %s, got %s", tenant.URL, cfg.TenantURL)
+	}
+	cfg.PersistPodRedirect = true
+	if err := finishLogin(client, cfg, "", AuthModeInteractive, "token"); err != nil {
+		t.Fatalf("finishLogin failed: %v", err)
+	}
+	if cfg.TenantURL != pod.URL {
+		t.Errorf("Expected TenantURL to be corrected to %s, got %s", pod.URL, cfg.TenantURL)
+	}
+}
+
+func TestAuthenticateFederated(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "summon-wpm-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	configFile := filepath.Join(tmpDir, "test-config.json")
+
+	origSleep := pollSleep
+	defer func() { pollSleep = origSleep }()
+	pollSleep = func(time.Duration) {}
+
+	var server *httptest.Server
+	var polls int
+	server = setupMockServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		switch r.URL.Path {
+		case StartAuthEndpoint:
+			w.Write([]byte(`{
+				"success": true,
+				"Result": {
+					"IdpRedirectUrl": "` + server.URL + `/idp/login?tenant=test",
+					"IdpLoginSessionId": "idp-session"
+				}
+			}`))
+		case OobAuthStatusEndpoint:
+			body, _ := io.ReadAll(r.Body)
+			if !bytes.Contains(body, []byte(`"SessionId":"idp-session"`)) {
+				t.Errorf("Unexpected IdP status body: %s", string(body))
+			}
+			polls++
+			if polls < 3 {
+				w.Write([]byte(`{"success": true, "Result": {"State": "Pending"}}`))
+				return
+			}
+			w.Write([]byte(`{"success": true, "Result": {"State": "Success", "Token": "federated-token"}}`))
+		default:
+			http.Error(w, "Not found", http.StatusNotFound)
+		}
+	})
+
+	var opened string
+	origOpenBrowser := OpenBrowser
+	defer func() { OpenBrowser = origOpenBrowser }()
+	OpenBrowser = func(target string) error {
+		opened = target
+		return nil
+	}
+
+	cfg := &config.Config{
+		TenantURL: server.URL,
+		Username:  "user@federated.example.com",
+	}
+
+	if err := AuthenticateInteractive(cfg, configFile, InteractiveOptions{}); err != nil {
+		t.Fatalf("AuthenticateInteractive failed: %v", err)
+	}
+
+	if opened != server.URL+"/idp/login?tenant=test" {
+		t.Errorf("Expected the IdP redirect to be opened unchanged, got %s", opened)
+	}
+	if polls != 3 {
+		t.Errorf("Expected 3 status polls, got %d", polls)
+	}
+	if cfg.AuthToken != "federated-token" {
+		t.Errorf("Expected AuthToken 'federated-token', got %s", cfg.AuthToken)
+	}
+}
+
+func TestAuthenticateWithBrowser(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "summon-wpm-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	configFile := filepath.Join(tmpDir, "test-config.json")
+
+	var codeChallenge string
+	server := setupMockServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case OAuthAuthorizeEndpoint + "test-app":
+			query := r.URL.Query()
+			if query.Get("client_id") != "test-public-client" || query.Get("code_challenge_method") != "S256" {
+				t.Errorf("Unexpected authorize request: %s", r.URL.RawQuery)
+			}
+			codeChallenge = query.Get("code_challenge")
+
+			callback, _ := url.Parse(query.Get("redirect_uri"))
+			callbackQuery := callback.Query()
+			callbackQuery.Set("code", "auth-code")
+			callbackQuery.Set("state", query.Get("state"))
+			callback.RawQuery = callbackQuery.Encode()
+			http.Redirect(w, r, callback.String(), http.StatusFound)
+		case OAuthTokenEndpoint + "test-app":
+			r.ParseForm()
+			switch r.PostForm.Get("grant_type") {
+			case "authorization_code":
+				// Verify the PKCE code verifier matches the challenge
+				sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+				if base64.RawURLEncoding.EncodeToString(sum[:]) != codeChallenge {
+					w.WriteHeader(http.StatusBadRequest)
+					w.Write([]byte(`{"error": "invalid_grant", "error_description": "PKCE verification failed"}`))
+					return
+				}
+				if r.PostForm.Get("code") != "auth-code" {
+					t.Errorf("Expected code 'auth-code', got %s", r.PostForm.Get("code"))
+				}
+				w.Write([]byte(`{"access_token": "browser-token", "expires_in": 3600, "refresh_token": "browser-refresh"}`))
+			case "refresh_token":
+				if r.PostForm.Get("refresh_token") != "browser-refresh" {
+					t.Errorf("Expected refresh token 'browser-refresh', got %s", r.PostForm.Get("refresh_token"))
+				}
+				w.Write([]byte(`{"access_token": "refreshed-token", "expires_in": 3600}`))
+			}
+		default:
+			http.Error(w, "Not found", http.StatusNotFound)
+		}
+	})
+
+	origOpenBrowser := OpenBrowser
+	defer func() { OpenBrowser = origOpenBrowser }()
+	OpenBrowser = func(target string) error {
+		// A stray callback with the wrong state must be ignored
+		query, _ := url.Parse(target)
+		stray, _ := url.Parse(query.Query().Get("redirect_uri"))
+		stray.RawQuery = url.Values{"code": {"stray-code"}, "state": {"wrong"}}.Encode()
+		resp, err := http.Get(stray.String())
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected stray callback to be rejected, got %s", resp.Status)
+		}
+
+		go func() {
+			resp, err := http.Get(target)
+			if err == nil {
+				resp.Body.Close()
+			}
+		}()
+		return nil
+	}
+
+	cfg := &config.Config{
+		TenantURL:     server.URL,
+		OAuthAppID:    "test-app",
+		OAuthClientID: "test-public-client",
+	}
+
+	if err := AuthenticateWithBrowser(cfg, configFile); err != nil {
+		t.Fatalf("AuthenticateWithBrowser failed: %v", err)
+	}
+	if cfg.AuthToken != "browser-token" || cfg.RefreshToken != "browser-refresh" {
+		t.Errorf("Unexpected tokens: access=%s refresh=%s", cfg.AuthToken, cfg.RefreshToken)
+	}
+
+	if err := RefreshAccessToken(cfg, configFile); err != nil {
+		t.Fatalf("RefreshAccessToken failed: %v", err)
+	}
+
+	savedCfg, err := config.LoadConfig(configFile)
+	if err != nil {
+		t.Fatalf("Failed to load saved config: %v", err)
+	}
+	if savedCfg.AuthToken != "refreshed-token" {
+		t.Errorf("Saved AuthToken = %s, want 'refreshed-token'", savedCfg.AuthToken)
+	}
+	if savedCfg.RefreshToken != "browser-refresh" {
+		t.Errorf("Saved RefreshToken = %s, want 'browser-refresh'", savedCfg.RefreshToken)
+	}
+	if savedCfg.TokenSource != TokenSourceBrowser || savedCfg.TokenRefreshedAt == 0 {
+		t.Errorf("Expected the refresh to keep source %q and record its time, got %q at %d",
+			TokenSourceBrowser, savedCfg.TokenSource, savedCfg.TokenRefreshedAt)
+	}
+}
+
+func TestAuthenticateWithDeviceCode(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "summon-wpm-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	configFile := filepath.Join(tmpDir, "test-config.json")
+
+	// The tenant asks the client to wait, then slow down, then issues tokens
+	polls := 0
+	server := setupMockServer(t, func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		switch r.URL.Path {
+		case OAuthDeviceEndpoint + "test-app":
+			w.Write([]byte(`{
+				"device_code": "dev-code",
+				"user_code": "ABCD-EFGH",
+				"verification_uri": "https://tenant.example.com/device",
+				"expires_in": 600,
+				"interval": 2
+			}`))
+		case OAuthTokenEndpoint + "test-app":
+			if r.PostForm.Get("grant_type") != deviceCodeGrantType || r.PostForm.Get("device_code") != "dev-code" {
+				t.Errorf("Unexpected token poll: %v", r.PostForm)
+			}
+			polls++
+			switch polls {
+			case 1:
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"error": "authorization_pending"}`))
+			case 2:
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"error": "slow_down"}`))
+			default:
+				w.Write([]byte(`{"access_token": "device-token", "expires_in": 3600, "refresh_token": "device-refresh"}`))
+			}
+		default:
+			http.Error(w, "Not found", http.StatusNotFound)
+		}
+	})
+
+	var waits []time.Duration
+	origSleep := pollSleep
+	defer func() { pollSleep = origSleep }()
+	pollSleep = func(d time.Duration) { waits = append(waits, d) }
+
+	cfg := &config.Config{
+		TenantURL:     server.URL,
+		OAuthAppID:    "test-app",
+		OAuthClientID: "test-public-client",
+	}
+
+	if err := AuthenticateWithDeviceCode(cfg, configFile); err != nil {
+		t.Fatalf("AuthenticateWithDeviceCode failed: %v", err)
+	}
+
+	if cfg.AuthToken != "device-token" || cfg.RefreshToken != "device-refresh" {
+		t.Errorf("Unexpected tokens: access=%s refresh=%s", cfg.AuthToken, cfg.RefreshToken)
+	}
+
+	expected := []time.Duration{2 * time.Second, 2 * time.Second, 7 * time.Second}
+	if len(waits) != len(expected) {
+		t.Fatalf("Expected %d polls, got %d (%v)", len(expected), len(waits), waits)
+	}
+	for i := range expected {
+		if waits[i] != expected[i] {
+			t.Errorf("Poll %d waited %s, want %s", i+1, waits[i], expected[i])
+		}
+	}
+}
+
+func TestAuthenticateWithWorkloadIdentity(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "summon-wpm-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	configFile := filepath.Join(tmpDir, "test-config.json")
+	jwtFile := filepath.Join(tmpDir, "token")
+	if err := os.WriteFile(jwtFile, []byte("k8s-jwt\n"), 0600); err != nil {
+		t.Fatalf("Failed to write JWT file: %v", err)
+	}
+
+	server := setupMockServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/github/token":
+			// Fake GitHub Actions OIDC endpoint
+			if r.Header.Get("Authorization") != "Bearer gh-request-token" || r.URL.Query().Get("audience") != "wpm" {
+				t.Errorf("Unexpected GitHub OIDC request: %s %s", r.Header.Get("Authorization"), r.URL.RawQuery)
+			}
+			w.Write([]byte(`{"value": "github-jwt"}`))
+		case OAuthTokenEndpoint + "wif-app":
+			r.ParseForm()
+			var subject string
+			switch r.PostForm.Get("grant_type") {
+			case tokenExchangeGrantType:
+				if r.PostForm.Get("subject_token_type") != jwtTokenType {
+					t.Errorf("Unexpected subject_token_type %s", r.PostForm.Get("subject_token_type"))
+				}
+				subject = r.PostForm.Get("subject_token")
+			case jwtBearerGrantType:
+				subject = r.PostForm.Get("assertion")
+			}
+			w.Write([]byte(`{"access_token": "exchanged-` + subject + `", "expires_in": 300}`))
+		default:
+			http.Error(w, "Not found", http.StatusNotFound)
+		}
+	})
+
+	t.Setenv("ACTIONS_ID_TOKEN_REQUEST_URL", server.URL+"/github/token?api-version=2.0")
+	t.Setenv("ACTIONS_ID_TOKEN_REQUEST_TOKEN", "gh-request-token")
+
+	tests := []struct {
+		source   string
+		grant    string
+		expected string
+	}{
+		{"kubernetes:" + jwtFile, "", "exchanged-k8s-jwt"},
+		{"file:" + jwtFile, SubjectTokenGrantJWTBearer, "exchanged-k8s-jwt"},
+		{"github:wpm", SubjectTokenGrantExchange, "exchanged-github-jwt"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			cfg := &config.Config{
+				TenantURL:          server.URL,
+				OAuthAppID:         "wif-app",
+				SubjectTokenSource: tt.source,
+				SubjectTokenGrant:  tt.grant,
+			}
+
+			if AuthMode(cfg) != AuthModeWorkloadIdentity {
+				t.Errorf("AuthMode() = %s, want %s", AuthMode(cfg), AuthModeWorkloadIdentity)
+			}
+			if err := AuthenticateService(cfg, configFile); err != nil {
+				t.Fatalf("AuthenticateService failed: %v", err)
+			}
+			if cfg.AuthToken != tt.expected {
+				t.Errorf("AuthToken = %s, want %s", cfg.AuthToken, tt.expected)
+			}
+		})
+	}
+}
+
+func TestAuthenticateWithPrivateKeyJWT(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "summon-wpm-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	configFile := filepath.Join(tmpDir, "test-config.json")
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate EC key: %v", err)
+	}
+
+	keys := map[string]crypto.Signer{"RS256": rsaKey, "ES256": ecKey}
+	for alg, key := range keys {
+		t.Run(alg, func(t *testing.T) {
+			der, err := x509.MarshalPKCS8PrivateKey(key)
+			if err != nil {
+				t.Fatalf("Failed to marshal key: %v", err)
+			}
+			keyFile := filepath.Join(tmpDir, alg+".pem")
+			if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+				t.Fatalf("Failed to write key: %v", err)
+			}
+
+			var server *httptest.Server
+			server = setupMockServer(t, func(w http.ResponseWriter, r *http.Request) {
+				r.ParseForm()
+				if r.PostForm.Get("client_secret") != "" {
+					t.Error("client_secret must not be sent with private_key_jwt")
+				}
+				if r.PostForm.Get("client_assertion_type") != clientAssertionType {
+					t.Errorf("Unexpected client_assertion_type %s", r.PostForm.Get("client_assertion_type"))
+				}
+
+				// Verify the assertion signature and claims
+				parts := strings.Split(r.PostForm.Get("client_assertion"), ".")
+				if len(parts) != 3 {
+					t.Fatalf("Malformed client assertion")
+				}
+				var header map[string]string
+				var claims map[string]interface{}
+				headerJSON, _ := base64.RawURLEncoding.DecodeString(parts[0])
+				claimsJSON, _ := base64.RawURLEncoding.DecodeString(parts[1])
+				signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+				json.Unmarshal(headerJSON, &header)
+				json.Unmarshal(claimsJSON, &claims)
+
+				if header["alg"] != alg || header["kid"] != "key-1" {
+					t.Errorf("Unexpected header: %v", header)
+				}
+				if claims["iss"] != "svc-client" || claims["sub"] != "svc-client" || claims["aud"] != server.URL+TokenEndpoint {
+					t.Errorf("Unexpected claims: %v", claims)
+				}
+
+				digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+				valid := false
+				switch k := key.(type) {
+				case *rsa.PrivateKey:
+					valid = rsa.VerifyPKCS1v15(&k.PublicKey, crypto.SHA256, digest[:], signature) == nil
+				case *ecdsa.PrivateKey:
+					r := new(big.Int).SetBytes(signature[:32])
+					s := new(big.Int).SetBytes(signature[32:])
+					valid = ecdsa.Verify(&k.PublicKey, digest[:], r, s)
+				}
+				if !valid {
+					t.Error("Client assertion signature did not verify")
+				}
+
+				w.Write([]byte(`{"access_token": "jwt-client-token", "expires_in": 3600}`))
+			})
+
+			cfg := &config.Config{
+				TenantURL:              server.URL,
+				ClientID:               "svc-client",
+				ClientAssertionKeyFile: keyFile,
+				ClientAssertionKeyID:   "key-1",
+			}
+
+			if AuthMode(cfg) != AuthModePrivateKeyJWT {
+				t.Errorf("AuthMode() = %s, want %s", AuthMode(cfg), AuthModePrivateKeyJWT)
+			}
+			if err := AuthenticateService(cfg, configFile); err != nil {
+				t.Fatalf("AuthenticateService failed: %v", err)
+			}
+			if cfg.AuthToken != "jwt-client-token" {
+				t.Errorf("AuthToken = %s, want jwt-client-token", cfg.AuthToken)
+			}
+		})
+	}
+}
+
+func TestAuthenticateWithClientCredentialsOAuthApp(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "summon-wpm-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	configFile := filepath.Join(tmpDir, "test-config.json")
+
+	server := setupMockServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != OAuthTokenEndpoint+"custom-app" {
+			t.Errorf("Expected request to %scustom-app, got %s", OAuthTokenEndpoint, r.URL.Path)
+		}
+
+		// client_secret_basic sends credentials in the header only
+		clientID, clientSecret, ok := r.BasicAuth()
+		if !ok || clientID != "svc%40example" || clientSecret != "s3cret%2F" {
+			t.Errorf("Unexpected basic auth: %q %q %v", clientID, clientSecret, ok)
+		}
+
+		r.ParseForm()
+		if r.PostForm.Get("client_secret") != "" || r.PostForm.Get("client_id") != "" {
+			t.Errorf("Client credentials must not be in the body: %v", r.PostForm)
+		}
+		if r.PostForm.Get("scope") != "wpm.read wpm.apps" {
+			t.Errorf("Expected scope 'wpm.read wpm.apps', got %q", r.PostForm.Get("scope"))
+		}
+		if r.PostForm.Get("audience") != "wpm-api" {
+			t.Errorf("Expected audience 'wpm-api', got %q", r.PostForm.Get("audience"))
+		}
+
+		w.Write([]byte(`{"access_token": "scoped-token", "expires_in": 3600}`))
+	})
+
+	cfg := &config.Config{
+		TenantURL:        server.URL,
+		ClientID:         "svc@example",
+		ClientSecret:     "s3cret/",
+		OAuthAppID:       "custom-app",
+		Scopes:           []string{"wpm.read", "wpm.apps"},
+		Audience:         "wpm-api",
+		ClientAuthMethod: ClientAuthSecretBasic,
+	}
+
+	if err := AuthenticateWithClientCredentials(cfg, configFile); err != nil {
+		t.Fatalf("AuthenticateWithClientCredentials failed: %v", err)
+	}
+	if cfg.AuthToken != "scoped-token" {
+		t.Errorf("AuthToken = %s, want scoped-token", cfg.AuthToken)
+	}
+}
+
+func TestAuthenticateWithCertificate(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "summon-wpm-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	configFile := filepath.Join(tmpDir, "test-config.json")
+
+	// Issue a client certificate from a throwaway CA
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test PKI"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("Failed to create CA: %v", err)
+	}
+	caCert, _ := x509.ParseCertificate(caDER)
+
+	clientKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	clientTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "build-host-01"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	clientDER, err := x509.CreateCertificate(rand.Reader, clientTemplate, caCert, &clientKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("Failed to create client certificate: %v", err)
+	}
+	clientKeyDER, _ := x509.MarshalPKCS8PrivateKey(clientKey)
+
+	certFile := filepath.Join(tmpDir, "client.pem")
+	keyFile := filepath.Join(tmpDir, "client-key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: clientDER}), 0600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: clientKeyDER}), 0600)
+
+	// Fake tenant that requires a client certificate signed by the CA
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != CertAuthEndpoint {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+		if len(r.TLS.PeerCertificates) == 0 || r.TLS.PeerCertificates[0].Subject.CommonName != "build-host-01" {
+			t.Errorf("Expected client certificate for build-host-01")
+		}
+		w.Write([]byte(`{"success": true, "Token": "cert-token"}`))
+	}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(caCert)
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.StartTLS()
+	defer server.Close()
+
+	serverCAFile := filepath.Join(tmpDir, "server-ca.pem")
+	os.WriteFile(serverCAFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600)
+
+	cfg := &config.Config{
+		TenantURL:      server.URL,
+		ClientCertFile: certFile,
+		ClientKeyFile:  keyFile,
+		CACertFile:     serverCAFile,
+	}
+
+	if AuthMode(cfg) != AuthModeCertificate {
+		t.Errorf("AuthMode() = %s, want %s", AuthMode(cfg), AuthModeCertificate)
+	}
+	if err := AuthenticateService(cfg, configFile); err != nil {
+		t.Fatalf("AuthenticateService failed: %v", err)
+	}
+	if cfg.AuthToken != "cert-token" {
+		t.Errorf("AuthToken = %s, want cert-token", cfg.AuthToken)
+	}
+
+	// A certificate that does not match the key must be rejected
+	cfg.ClientCertFile = serverCAFile
+	if err := AuthenticateService(cfg, configFile); err == nil {
+		t.Error("Expected error with mismatched client certificate and key")
+	}
+}
+
+// mockTerminal replaces the interactive input for the duration of a test
+func mockTerminal(t *testing.T, visible string, secrets ...string) {
+	origStdin, origReadSecret := stdin, readSecret
+	t.Cleanup(func() {
+		stdin, readSecret = origStdin, origReadSecret
+	})
+
+	stdin = strings.NewReader(visible)
+	readSecret = func() (string, error) {
+		if len(secrets) == 0 {
+			t.Fatal("Unexpected secret prompt")
+		}
+		secret := secrets[0]
+		secrets = secrets[1:]
+		return secret, nil
+	}
+}
+
+func TestAuthenticateInteractivePasswordExpired(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "summon-wpm-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	configFile := filepath.Join(tmpDir, "test-config.json")
+
+	server := setupMockServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		switch r.URL.Path {
+		case StartAuthEndpoint:
+			w.Write([]byte(`{
+				"success": true,
+				"SessionId": "session-1",
+				"Challenges": [{"Mechanisms": [{"MechanismId": "up", "Name": "Password"}]}]
+			}`))
+		case AdvanceAuthEndpoint:
+			var req AdvanceAuthRequest
+			json.NewDecoder(r.Body).Decode(&req)
+
+			switch req.MechanismID {
+			case "up":
+				if req.Answer != "old-password" {
+					t.Errorf("Expected old password, got %q", req.Answer)
+				}
+				// The password has expired, so a reset challenge follows
+				w.Write([]byte(`{
+					"success": true,
+					"Result": {
+						"Summary": "NewPackage",
+						"Challenges": [{"Mechanisms": [{"MechanismId": "reset", "Name": "RESET", "PromptMechChosen": "Your password has expired."}]}]
+					}
+				}`))
+			case "reset":
+				if req.Answer != "new-password" {
+					t.Errorf("Expected new password, got %q", req.Answer)
+				}
+				w.Write([]byte(`{"success": true, "Result": {"Summary": "LoginSuccess", "Token": "after-reset-token"}}`))
+			default:
+				t.Errorf("Unexpected mechanism %q", req.MechanismID)
+			}
+		}
+	})
+
+	// The first confirmation does not match and is asked again
+	mockTerminal(t, "1\n1\n", "old-password", "new-password", "typo", "new-password", "new-password")
+
+	cfg := &config.Config{
+		TenantURL: server.URL,
+		Username:  "user@example.com",
+	}
+
+	if err := AuthenticateInteractive(cfg, configFile, InteractiveOptions{}); err != nil {
+		t.Fatalf("AuthenticateInteractive failed: %v", err)
+	}
+	if cfg.AuthToken != "after-reset-token" {
+		t.Errorf("AuthToken = %s, want after-reset-token", cfg.AuthToken)
+	}
+}
+
+func TestAuthenticateInteractiveNewPackageLimit(t *testing.T) {
+	server := setupMockServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		switch r.URL.Path {
+		case StartAuthEndpoint:
+			w.Write([]byte(`{
+				"success": true,
+				"SessionId": "session-1",
+				"Challenges": [{"Mechanisms": [{"MechanismId": "up", "Name": "Password"}]}]
+			}`))
+		case AdvanceAuthEndpoint:
+			// A misbehaving tenant keeps sending the same package
+			w.Write([]byte(`{
+				"success": true,
+				"Result": {
+					"Summary": "NewPackage",
+					"Challenges": [{"Mechanisms": [{"MechanismId": "up", "Name": "Password"}]}]
+				}
+			}`))
+		}
+	})
+
+	secrets := make([]string, maxNewPackages+1)
+	for i := range secrets {
+		secrets[i] = "password"
+	}
+	mockTerminal(t, strings.Repeat("1\n", maxNewPackages+1), secrets...)
+
+	cfg := &config.Config{
+		TenantURL: server.URL,
+		Username:  "user@example.com",
+	}
+
+	err := AuthenticateInteractive(cfg, "", InteractiveOptions{})
+	if err == nil || !strings.Contains(err.Error(), "too many new challenge packages") {
+		t.Errorf("Expected the new package limit to stop the login, got %v", err)
+	}
+}
+
+func TestAuthenticateInteractiveMechanisms(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "summon-wpm-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	configFile := filepath.Join(tmpDir, "test-config.json")
+
+	origSleep := pollSleep
+	defer func() { pollSleep = origSleep }()
+	pollSleep = func(time.Duration) {}
+
+	var actions []string
+	polls := 0
+	server := setupMockServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		switch r.URL.Path {
+		case StartAuthEndpoint:
+			w.Write([]byte(`{
+				"success": true,
+				"SessionId": "session-1",
+				"Challenges": [
+					{"Mechanisms": [{"MechanismId": "sms", "Name": "SMS", "AnswerType": "StartTextOob", "PartialDeviceAddress": "XXX-XXX-1234"}]},
+					{"Mechanisms": [{"MechanismId": "push", "Name": "PF", "AnswerType": "StartOob"}]},
+					{"Mechanisms": [{"MechanismId": "radius", "Name": "RADIUS", "AnswerType": "Text"}]}
+				]
+			}`))
+		case AdvanceAuthEndpoint:
+			var req AdvanceAuthRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			actions = append(actions, req.MechanismID+":"+req.Action+":"+req.Answer)
+
+			switch {
+			case req.MechanismID == "push" && req.Action == ActionPoll && polls == 0:
+				polls++
+				w.Write([]byte(`{"success": true, "Result": {"Summary": "OobPending"}}`))
+			case req.MechanismID == "push" && req.Action == ActionStartOOB:
+				w.Write([]byte(`{"success": true, "Result": {"Summary": "OobPending"}}`))
+			case req.MechanismID == "radius" && req.Answer == "passcode":
+				w.Write([]byte(`{"success": true, "Result": {"Summary": "RadiusChallenge", "Message": "Enter next token code"}}`))
+			case req.MechanismID == "radius":
+				w.Write([]byte(`{"success": true, "Result": {"Summary": "LoginSuccess", "Token": "mfa-token"}}`))
+			default:
+				w.Write([]byte(`{"success": true, "Result": {"Summary": "StartNextChallenge"}}`))
+			}
+		}
+	})
+
+	mockTerminal(t, "123456\n", "passcode", "654321")
+
+	cfg := &config.Config{
+		TenantURL:           server.URL,
+		Username:            "user@example.com",
+		PreferredMechanisms: []string{"SMS", "PF", "RADIUS"},
+	}
+
+	if err := AuthenticateInteractive(cfg, configFile, InteractiveOptions{}); err != nil {
+		t.Fatalf("AuthenticateInteractive failed: %v", err)
+	}
+	if cfg.AuthToken != "mfa-token" {
+		t.Errorf("AuthToken = %s, want mfa-token", cfg.AuthToken)
+	}
+
+	expected := []string{
+		"sms:StartOOB:", "sms:Answer:123456",
+		"push:StartOOB:", "push:Poll:", "push:Poll:",
+		"radius:Answer:passcode", "radius:Answer:654321",
+	}
+	if strings.Join(actions, ",") != strings.Join(expected, ",") {
+		t.Errorf("actions = %v, want %v", actions, expected)
+	}
+}
+
+func TestAuthenticateInteractiveDeviceCookie(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "summon-wpm-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	configFile := filepath.Join(tmpDir, "test-config.json")
+
+	trusted := false
+	server := setupMockServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		switch r.URL.Path {
+		case StartAuthEndpoint:
+			if cookie, err := r.Cookie("device_trust"); err == nil && cookie.Value == "trusted" {
+				trusted = true
+			}
+			http.SetCookie(w, &http.Cookie{Name: "login_session", Value: "abc"})
+			w.Write([]byte(`{
+				"success": true,
+				"SessionId": "session-1",
+				"Challenges": [{"Mechanisms": [{"MechanismId": "up", "Name": "UP"}]}]
+			}`))
+		case AdvanceAuthEndpoint:
+			// The login session cookie must be sent back within the login
+			if _, err := r.Cookie("login_session"); err != nil {
+				t.Error("Expected login_session cookie on advance authentication")
+			}
+			http.SetCookie(w, &http.Cookie{Name: "device_trust", Value: "trusted", MaxAge: 3600})
+			w.Write([]byte(`{"success": true, "Result": {"Summary": "LoginSuccess", "Token": "session-token"}}`))
+		}
+	})
+
+	mockTerminal(t, "", "password", "password")
+
+	cfg := &config.Config{
+		TenantURL:           server.URL,
+		Username:            "user@example.com",
+		PreferredMechanisms: []string{"UP"},
+	}
+	if err := AuthenticateInteractive(cfg, configFile, InteractiveOptions{}); err != nil {
+		t.Fatalf("AuthenticateInteractive failed: %v", err)
+	}
+	if trusted {
+		t.Error("Device cookie sent before it was issued")
+	}
+
+	// Only the persistent cookie is stored
+	savedCfg, err := config.LoadConfig(configFile)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if len(savedCfg.DeviceCookies) != 1 || savedCfg.DeviceCookies[0].Name != "device_trust" {
+		t.Fatalf("DeviceCookies = %+v, want only device_trust", savedCfg.DeviceCookies)
+	}
+
+	// The next login presents the stored cookie
+	if err := AuthenticateInteractive(savedCfg, configFile, InteractiveOptions{}); err != nil {
+		t.Fatalf("Second AuthenticateInteractive failed: %v", err)
+	}
+	if !trusted {
+		t.Error("Expected stored device_trust cookie on the next StartAuthentication")
+	}
+}
+
+func TestMatchMechanism(t *testing.T) {
+	mechanisms := []Mechanism{
+		{MechanismID: "up", Name: "UP", PromptSelectMech: "Password"},
+		{MechanismID: "oath", Name: "OATH", PromptSelectMech: "OATH OTP Client"},
+		{MechanismID: "otp", Name: "OTP", PromptSelectMech: "Mobile Authenticator"},
+	}
+
+	tests := []struct {
+		name        string
+		preferences []string
+		expected    string
+		found       bool
+	}{
+		{"No preferences", nil, "", false},
+		{"By display text", []string{"Mobile Authenticator", "OATH"}, "otp", true},
+		{"By name, case insensitive", []string{"oath"}, "oath", true},
+		{"Falls through to second preference", []string{"SMS", "OATH"}, "oath", true},
+		{"Nothing offered", []string{"SMS", "EMAIL"}, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mechanism, found := matchMechanism(mechanisms, tt.preferences)
+			if found != tt.found || mechanism.MechanismID != tt.expected {
+				t.Errorf("matchMechanism(%v) = %q, %v; want %q, %v", tt.preferences, mechanism.MechanismID, found, tt.expected, tt.found)
+			}
+		})
+	}
+
+	// A one-off override replaces the profile preferences
+	cfg := &config.Config{PreferredMechanisms: []string{"OATH"}}
+	if prefs := mechanismPreferences(cfg, InteractiveOptions{Mechanism: "UP"}); len(prefs) != 1 || prefs[0] != "UP" {
+		t.Errorf("mechanismPreferences() = %v, want [UP]", prefs)
+	}
+}
+
+func TestLogout(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "summon-wpm-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	configFile := filepath.Join(tmpDir, "test-config.json")
+
+	var revoked []string
+	server := setupMockServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case LogoutEndpoint:
+			if r.Header.Get("Authorization") != "Bearer session-token" {
+				t.Errorf("Unexpected Authorization header %q", r.Header.Get("Authorization"))
+			}
+			revoked = append(revoked, "session")
+			w.Write([]byte(`{"success": true}`))
+		case OAuthRevokeEndpoint + "test-app":
+			r.ParseForm()
+			if r.Form.Get("client_id") != "test-public-client" {
+				t.Errorf("Unexpected client_id %q", r.Form.Get("client_id"))
+			}
+			revoked = append(revoked, r.Form.Get("token_type_hint")+"="+r.Form.Get("token"))
+		default:
+			t.Errorf("Unexpected request to %s", r.URL.Path)
+		}
+	})
+
+	t.Run("Session token", func(t *testing.T) {
+		revoked = nil
+		cfg := &config.Config{
+			TenantURL:     server.URL,
+			AuthToken:     "session-token",
+			TokenExpiry:   time.Now().Add(time.Hour).Unix(),
+			TokenSource:   AuthModeInteractive,
+			DeviceCookies: []config.DeviceCookie{{Name: "device_trust", Value: "trusted"}},
+		}
+
+		result, err := Logout(cfg, configFile)
+		if err != nil {
+			t.Fatalf("Logout failed: %v", err)
+		}
+		if strings.Join(revoked, ",") != "session" || strings.Join(result.Revoked, ",") != "access token" {
+			t.Errorf("revoked = %v, result = %+v", revoked, result)
+		}
+		if len(result.Removed) != 2 {
+			t.Errorf("Removed = %v, want tokens and cookies", result.Removed)
+		}
+
+		savedCfg, err := config.LoadConfig(configFile)
+		if err != nil {
+			t.Fatalf("Failed to load config: %v", err)
+		}
+		if savedCfg.AuthToken != "" || savedCfg.TokenExpiry != 0 || savedCfg.TokenSource != "" || len(savedCfg.DeviceCookies) != 0 {
+			t.Errorf("Local state not removed: %+v", savedCfg)
+		}
+	})
+
+	t.Run("OAuth application tokens", func(t *testing.T) {
+		revoked = nil
+		cfg := &config.Config{
+			TenantURL:     server.URL,
+			OAuthAppID:    "test-app",
+			OAuthClientID: "test-public-client",
+			AuthToken:     "access",
+			RefreshToken:  "refresh",
+		}
+
+		result, err := Logout(cfg, configFile)
+		if err != nil {
+			t.Fatalf("Logout failed: %v", err)
+		}
+		if strings.Join(revoked, ",") != "refresh_token=refresh,access_token=access" {
+			t.Errorf("revoked = %v", revoked)
+		}
+		if len(result.Failed) != 0 {
+			t.Errorf("Failed = %v", result.Failed)
+		}
+		if cfg.RefreshToken != "" || cfg.AuthToken != "" {
+			t.Errorf("Tokens not cleared: %+v", cfg)
+		}
+	})
+}
+
+func TestGetStatus(t *testing.T) {
+	server := setupMockServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != WhoAmIEndpoint {
+			t.Errorf("Unexpected request to %s", r.URL.Path)
+		}
+		w.Write([]byte(`{"success": true, "Result": {"User": "user@example.com", "UserUuid": "uuid-1", "TenantId": "ABC123"}}`))
+	})
+
+	expiry := time.Now().Add(30 * time.Minute).Unix()
+	claims := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"exp": %d}`, expiry)))
+	token := "eyJhbGciOiJub25lIn0." + claims + ".sig"
+
+	t.Run("Valid token", func(t *testing.T) {
+		cfg := &config.Config{
+			TenantURL:   server.URL,
+			Username:    "user@example.com",
+			AuthToken:   token,
+			TokenExpiry: time.Now().Add(time.Hour).Unix(),
+			TokenSource: AuthModeInteractive,
+		}
+
+		status := GetStatus(cfg, "default")
+		if status.NeedsAuth || status.Reason != "" {
+			t.Errorf("NeedsAuth = %v, Reason = %q", status.NeedsAuth, status.Reason)
+		}
+		if status.ExpiresAt == nil || status.ExpiresAt.Unix() != expiry {
+			t.Errorf("ExpiresAt = %v, want expiry from the JWT claims", status.ExpiresAt)
+		}
+		if status.Identity == nil || status.Identity.UserUUID != "uuid-1" {
+			t.Errorf("Identity = %+v", status.Identity)
+		}
+
+		// The token itself is never part of the status
+		data, _ := json.Marshal(status)
+		if strings.Contains(string(data), claims) {
+			t.Error("Status contains the token")
+		}
+	})
+
+	t.Run("Expired token", func(t *testing.T) {
+		cfg := &config.Config{
+			TenantURL:   server.URL,
+			AuthToken:   "opaque-token",
+			TokenExpiry: time.Now().Add(-time.Minute).Unix(),
+		}
+
+		status := GetStatus(cfg, "default")
+		if !status.NeedsAuth || status.Renewable || status.Reason != "stored token has expired" || status.Identity != nil {
+			t.Errorf("Status = %+v", status)
+		}
+	})
+
+	t.Run("Expired token with refresh token", func(t *testing.T) {
+		cfg := &config.Config{
+			TenantURL:    server.URL,
+			AuthToken:    "opaque-token",
+			TokenExpiry:  time.Now().Add(-time.Minute).Unix(),
+			RefreshToken: "refresh",
+		}
+
+		status := GetStatus(cfg, "default")
+		if status.NeedsAuth || !status.Renewable || status.Identity != nil {
+			t.Errorf("Status = %+v", status)
+		}
+	})
+
+	t.Run("No token with service credentials", func(t *testing.T) {
+		cfg := &config.Config{
+			TenantURL:    server.URL,
+			ClientID:     "svc",
+			ClientSecret: "secret",
+		}
+
+		status := GetStatus(cfg, "default")
+		if status.NeedsAuth || !status.Renewable {
+			t.Errorf("Status = %+v", status)
+		}
+	})
+}
+
+func TestListApps(t *testing.T) {
+	var pages []int
+	server := setupMockServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != GetUPDataEndpoint {
+			t.Errorf("Unexpected request to %s", r.URL.Path)
+		}
+		if r.Header.Get("Authorization") != "Bearer test-token" {
+			t.Errorf("Unexpected Authorization header %q", r.Header.Get("Authorization"))
+		}
+
+		var req appsRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		pages = append(pages, req.Args.PageNumber)
+
+		// A full first page, then a short last one
+		count := appsPageSize
+		if req.Args.PageNumber == 2 {
+			count = 2
+		}
+		var apps []App
+		for i := 0; i < count; i++ {
+			apps = append(apps, App{
+				AppKey:      fmt.Sprintf("key-%d-%d", req.Args.PageNumber, i),
+				DisplayName: fmt.Sprintf("App %d-%d", req.Args.PageNumber, i),
+				AppType:     "Web",
+			})
+		}
+		apps[0].DisplayName = "Oracle Database"
+		apps[0].CredentialSet = true
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"Result":  map[string]interface{}{"Apps": apps, "FullCount": appsPageSize + 2},
+		})
+	})
+
+	cfg := &config.Config{TenantURL: server.URL, AuthToken: "test-token"}
+
+	apps, err := ListApps(cfg, "")
+	if err != nil {
+		t.Fatalf("ListApps failed: %v", err)
+	}
+	if len(apps) != appsPageSize+2 || fmt.Sprint(pages) != "[1 2]" {
+		t.Errorf("Got %d apps from pages %v", len(apps), pages)
+	}
+
+	apps, err = ListApps(cfg, "oracle")
+	if err != nil {
+		t.Fatalf("ListApps with filter failed: %v", err)
+	}
+	if len(apps) != 2 || apps[0].AppKey != "key-1-0" || !apps[0].CredentialSet {
+		t.Errorf("Filtered apps = %+v", apps)
+	}
+}
+
+func TestSaveTokenResponseDefaultExpiry(t *testing.T) {
+	cfg := &config.Config{}
+	if err := saveTokenResponse(cfg, "", TokenSourceBrowser, &TokenResponse{AccessToken: "token"}); err != nil {
+		t.Fatalf("saveTokenResponse failed: %v", err)
+	}
+
+	remaining := time.Until(time.Unix(cfg.TokenExpiry, 0))
+	if remaining <= 59*time.Minute || remaining > time.Hour {
+		t.Errorf("Expected a token without expires_in to last an hour, got %s", remaining)
+	}
+}
@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+)
+
+// callbackPath is the path of the loopback redirect URI
+const callbackPath = "/callback"
+
+// browserLoginTimeout is how long to wait for the browser to call back
+var browserLoginTimeout = 5 * time.Minute
+
+// OpenBrowser opens a URL in the user's default browser. It is a variable so
+// tests can replace it with a headless client.
+var OpenBrowser = openBrowser
+
+// openBrowser launches the platform's URL handler
+func openBrowser(target string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", target)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", target)
+	default:
+		cmd = exec.Command("xdg-open", target)
+	}
+	return cmd.Start()
+}
+
+// loopbackServer receives a single browser redirect on 127.0.0.1
+type loopbackServer struct {
+	RedirectURI string
+	server      *http.Server
+	results     chan url.Values
+}
+
+// newLoopbackServer listens on a random local port for the callback carrying
+// state. Requests with any other state are answered with an error and
+// ignored, so a stray request cannot end the login.
+func newLoopbackServer(state string) (*loopbackServer, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("error starting callback listener: %s", err)
+	}
+
+	l := &loopbackServer{
+		RedirectURI: fmt.Sprintf("http://%s%s", listener.Addr().String(), callbackPath),
+		results:     make(chan url.Values, 1),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(callbackPath, func(w http.ResponseWriter, r *http.Request) {
+		params := r.URL.Query()
+		if params.Get("state") != state {
+			http.Error(w, "Unexpected login callback", http.StatusBadRequest)
+			return
+		}
+		select {
+		case l.results <- params:
+		default:
+			// Only the first callback is used
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, "<html><body>Login complete. You can close this window and return to the terminal.</body></html>")
+	})
+
+	l.server = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go l.server.Serve(listener)
+
+	return l, nil
+}
+
+// wait blocks until the callback with the expected state arrives
+func (l *loopbackServer) wait() (url.Values, error) {
+	select {
+	case params := <-l.results:
+		if errMsg := params.Get("error"); errMsg != "" {
+			if desc := params.Get("error_description"); desc != "" {
+				errMsg += ": " + desc
+			}
+			return nil, fmt.Errorf("login was rejected: %s", errMsg)
+		}
+		return params, nil
+	case <-time.After(browserLoginTimeout):
+		return nil, errors.New("timed out waiting for browser login")
+	}
+}
+
+// Close stops the listener
+func (l *loopbackServer) Close() {
+	l.server.Close()
+}
+
+// openBrowserTo sends the user to target, printing it in case no browser opens
+func openBrowserTo(target string) {
+	fmt.Fprintf(os.Stderr, "Opening browser to complete login. If it does not open, visit:\n%s\n", target)
+	if err := OpenBrowser(target); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not open browser: %s\n", err)
+	}
+}
+
+// randomString returns n random bytes encoded as unpadded base64url
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/infamousjoeg/summon-wpm/internal/api"
+	"github.com/infamousjoeg/summon-wpm/internal/config"
+)
+
+// AuthenticateWithCertificate logs in by presenting the host's X.509 client
+// certificate over mutual TLS to the tenant's certificate authentication
+// endpoint, which answers with a session token. No password is involved.
+func AuthenticateWithCertificate(cfg *config.Config, configFile string) error {
+	client, err := newCertificateClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	baseURL := cfg.CertAuthURL
+	if baseURL == "" {
+		baseURL = cfg.TenantURL
+	}
+
+	body, err := json.Marshal(StartAuthRequest{
+		User:    cfg.Username,
+		Version: "1.0",
+	})
+	if err != nil {
+		return fmt.Errorf("error marshaling certificate auth request: %s", err)
+	}
+
+	req, err := http.NewRequest("POST", strings.TrimRight(baseURL, "/")+CertAuthEndpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating request: %s", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("certificate authentication request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return &api.StatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+
+	certAuthResp, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error reading response: %s", err)
+	}
+
+	var certAuthResponse AdvanceAuthResponse
+	if err := json.Unmarshal(certAuthResp, &certAuthResponse); err != nil {
+		return fmt.Errorf("error parsing certificate auth response: %s", err)
+	}
+
+	if !certAuthResponse.Success || certAuthResponse.SessionToken() == "" {
+		return fmt.Errorf("certificate authentication failed: %s", certAuthResponse.ErrorMsg)
+	}
+
+	return saveSessionToken(cfg, configFile, AuthModeCertificate, certAuthResponse.SessionToken())
+}
+
+// newCertificateClient builds an HTTP client that presents the client certificate
+func newCertificateClient(cfg *config.Config) (*http.Client, error) {
+	keyFile := cfg.ClientKeyFile
+	if keyFile == "" {
+		// The key may be bundled in the same PEM file as the certificate
+		keyFile = cfg.ClientCertFile
+	}
+
+	cert, err := tls.LoadX509KeyPair(cfg.ClientCertFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error loading client certificate: %s", err)
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if cfg.CACertFile != "" {
+		caPEM, err := os.ReadFile(cfg.CACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading CA certificate: %s", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, errors.New("no certificates found in ca_cert_file")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}, nil
+}
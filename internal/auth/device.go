@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/infamousjoeg/summon-wpm/internal/config"
+)
+
+const deviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+// defaultDevicePollInterval is used when the tenant does not send an interval
+const defaultDevicePollInterval = 5 * time.Second
+
+// pollSleep waits between polls of the tenant. It is a variable so tests can skip the wait.
+var pollSleep = time.Sleep
+
+// AuthenticateWithDeviceCode logs a user in with the OAuth2 device
+// authorization grant (RFC 8628). The verification URL and user code are
+// printed so the login can be completed from any other device, which makes
+// it usable on SSH sessions and jump boxes without a browser.
+func AuthenticateWithDeviceCode(cfg *config.Config, configFile string) error {
+	deviceURL, err := oauthEndpoint(cfg, OAuthDeviceEndpoint)
+	if err != nil {
+		return err
+	}
+	tokenURL, err := oauthEndpoint(cfg, OAuthTokenEndpoint)
+	if err != nil {
+		return err
+	}
+	if cfg.OAuthClientID == "" {
+		return errors.New("no OAuth client ID configured (set oauth_client_id)")
+	}
+
+	deviceAuth, err := requestDeviceAuthorization(deviceURL, cfg.OAuthClientID, scopeParam(cfg, browserLoginScope))
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "To log in, visit: %s\n", deviceAuth.VerificationURI)
+	fmt.Fprintf(os.Stderr, "and enter the code: %s\n", deviceAuth.UserCode)
+	if deviceAuth.VerificationURIComplete != "" {
+		fmt.Fprintf(os.Stderr, "Or open: %s\n", deviceAuth.VerificationURIComplete)
+	}
+
+	interval := time.Duration(deviceAuth.Interval) * time.Second
+	if interval <= 0 {
+		interval = defaultDevicePollInterval
+	}
+	deadline := time.Now().Add(time.Duration(deviceAuth.ExpiresIn) * time.Second)
+
+	data := url.Values{}
+	data.Set("grant_type", deviceCodeGrantType)
+	data.Set("device_code", deviceAuth.DeviceCode)
+	data.Set("client_id", cfg.OAuthClientID)
+
+	for {
+		pollSleep(interval)
+
+		tokenResponse, err := requestToken(tokenURL, data)
+		if err == nil {
+			return saveTokenResponse(cfg, configFile, TokenSourceDevice, tokenResponse)
+		}
+
+		var oauthErr *OAuthError
+		if !errors.As(err, &oauthErr) {
+			return err
+		}
+
+		switch oauthErr.Code {
+		case "authorization_pending":
+			// Keep polling at the current interval
+		case "slow_down":
+			interval += 5 * time.Second
+		case "access_denied":
+			return errors.New("login was denied")
+		case "expired_token":
+			return errors.New("device code expired before login was completed")
+		default:
+			return err
+		}
+
+		if deviceAuth.ExpiresIn > 0 && time.Now().After(deadline) {
+			return errors.New("device code expired before login was completed")
+		}
+	}
+}
+
+// requestDeviceAuthorization asks the tenant for a device and user code
+func requestDeviceAuthorization(deviceURL, clientID, scope string) (*DeviceAuthResponse, error) {
+	data := url.Values{}
+	data.Set("client_id", clientID)
+	data.Set("scope", scope)
+
+	req, err := http.NewRequest("POST", deviceURL, strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %s", err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("device authorization request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response: %s", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var oauthErr OAuthError
+		if json.Unmarshal(body, &oauthErr) == nil && oauthErr.Code != "" {
+			return nil, &oauthErr
+		}
+		return nil, fmt.Errorf("request failed with status: %s", resp.Status)
+	}
+
+	var deviceAuth DeviceAuthResponse
+	if err := json.Unmarshal(body, &deviceAuth); err != nil {
+		return nil, fmt.Errorf("error parsing device authorization response: %s", err)
+	}
+
+	if deviceAuth.DeviceCode == "" || deviceAuth.UserCode == "" {
+		return nil, errors.New("no device code received")
+	}
+
+	return &deviceAuth, nil
+}
@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/infamousjoeg/summon-wpm/internal/api"
+	"github.com/infamousjoeg/summon-wpm/internal/config"
+)
+
+// authenticateFederated completes a login for users federated to an external
+// IdP. The IdP redirect is opened in the browser and the IdP login session is
+// polled until the tenant issues a session token.
+//
+// Identity posts the IdP's SAML or OIDC response back to its own assertion
+// consumer URL, not to a redirect URI we choose, so a localhost callback
+// listener would never be called. The session token is only handed out
+// through OobAuthStatus once the tenant has accepted the assertion.
+func authenticateFederated(client *api.Session, cfg *config.Config, configFile string, startAuthResponse *StartAuthResponse) error {
+	sessionID := startAuthResponse.Result.IdpLoginSessionID
+	if sessionID == "" {
+		return errors.New("federated authentication failed: no IdP login session received")
+	}
+
+	openBrowserTo(startAuthResponse.Result.IdpRedirectURL)
+	fmt.Fprintln(os.Stderr, "Waiting for the IdP login to complete...")
+
+	deadline := time.Now().Add(browserLoginTimeout)
+	for {
+		status, err := oobAuthStatus(client, sessionID)
+		if err != nil {
+			return err
+		}
+
+		if status.Result.Token != "" {
+			return finishLogin(client, cfg, configFile, TokenSourceFederated, status.Result.Token)
+		}
+
+		switch status.Result.State {
+		case OobStateSuccess:
+			return errors.New("federated authentication failed: no token received")
+		case "", OobStatePending:
+			// The user has not finished at the IdP yet
+		default:
+			return fmt.Errorf("federated authentication failed: %s", status.Result.State)
+		}
+
+		if time.Now().After(deadline) {
+			return errors.New("timed out waiting for IdP login")
+		}
+		pollSleep(oobPollInterval)
+	}
+}
+
+// oobAuthStatus polls the state of an IdP login session
+func oobAuthStatus(client *api.Session, sessionID string) (*OobAuthStatusResponse, error) {
+	body, err := json.Marshal(OobAuthStatusRequest{SessionID: sessionID})
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling IdP status request: %s", err)
+	}
+
+	resp, err := client.MakeRequest("POST", OobAuthStatusEndpoint, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, fmt.Errorf("IdP status request failed: %w", err)
+	}
+
+	var status OobAuthStatusResponse
+	if err := json.Unmarshal(resp, &status); err != nil {
+		return nil, fmt.Errorf("error parsing IdP status response: %s", err)
+	}
+
+	if !status.Success {
+		return nil, fmt.Errorf("federated authentication failed: %s", status.ErrorMsg)
+	}
+
+	return &status, nil
+}
@@ -8,50 +8,124 @@ import (
 	"fmt"
 	"os"
 	"strings"
-	"syscall"
 	"time"
 
-	"golang.org/x/term"
-
 	"github.com/infamousjoeg/summon-wpm/internal/api"
 	"github.com/infamousjoeg/summon-wpm/internal/config"
+	"github.com/infamousjoeg/summon-wpm/internal/discovery"
 )
 
+// maxPodRedirects bounds how many PodFqdn redirects are followed
+const maxPodRedirects = 3
+
+// maxNewPackages bounds how many times the tenant may replace the remaining
+// challenges with a new package during one login
+const maxNewPackages = 5
+
+// InteractiveOptions adjusts a single interactive login
+type InteractiveOptions struct {
+	// Mechanism is used instead of the profile's preferred mechanisms
+	Mechanism string
+}
+
 // AuthenticateInteractive performs interactive authentication with user input
-func AuthenticateInteractive(cfg *config.Config, configFile string) error {
-	// Start authentication
-	startAuthReq := StartAuthRequest{
-		User:    cfg.Username,
-		Version: "1.0",
-	}
+func AuthenticateInteractive(cfg *config.Config, configFile string, opts InteractiveOptions) error {
+	// One cookie-aware client for the whole login, so stored trusted-device
+	// cookies are sent and new ones can be kept
+	client := api.NewSession(cfg)
 
-	startAuthBody, err := json.Marshal(startAuthReq)
+	// Start authentication, following redirects to the user's pod
+	startAuthResponse, err := startAuthentication(client, cfg)
 	if err != nil {
-		return fmt.Errorf("error marshaling start auth request: %s", err)
+		return err
 	}
 
-	startAuthResp, err := api.MakeRequest(cfg, "POST", StartAuthEndpoint, bytes.NewBuffer(startAuthBody))
-	if err != nil {
-		return fmt.Errorf("start authentication request failed: %s", err)
+	// Users federated to an external IdP log in through the browser
+	if startAuthResponse.Result.IdpRedirectURL != "" {
+		return authenticateFederated(client, cfg, configFile, startAuthResponse)
 	}
 
-	var startAuthResponse StartAuthResponse
-	if err := json.Unmarshal(startAuthResp, &startAuthResponse); err != nil {
-		return fmt.Errorf("error parsing start auth response: %s", err)
+	// Handle authentication challenges
+	if len(startAuthResponse.Challenges) == 0 {
+		return errors.New("no authentication challenges received")
 	}
 
-	if !startAuthResponse.Success {
-		return fmt.Errorf("start authentication failed: %s", startAuthResponse.ErrorMsg)
+	session := &loginSession{
+		client:    client,
+		reader:    bufio.NewReader(stdin),
+		sessionID: startAuthResponse.SessionID,
 	}
+	challenges := startAuthResponse.Challenges
 
-	// Handle authentication challenges
-	if len(startAuthResponse.Challenges) == 0 {
-		return errors.New("no authentication challenges received")
+	// Answer each challenge in turn. The tenant may replace the remaining
+	// challenges with a new package, e.g. when the password has expired.
+	newPackages := 0
+	for i := 0; i < len(challenges); i++ {
+		mechanism, err := selectMechanism(challenges[i], session.reader, mechanismPreferences(cfg, opts))
+		if err != nil {
+			return err
+		}
+
+		advanceAuthResponse, err := handlerFor(mechanism)(session, mechanism)
+		if err != nil {
+			return err
+		}
+
+		if token := advanceAuthResponse.SessionToken(); token != "" {
+			return finishLogin(client, cfg, configFile, AuthModeInteractive, token)
+		}
+
+		if advanceAuthResponse.Result.Summary == SummaryNewPackage {
+			newPackages++
+			if newPackages > maxNewPackages {
+				return errors.New("too many new challenge packages")
+			}
+			challenges = advanceAuthResponse.Result.Challenges
+			i = -1
+		}
+	}
+
+	return errors.New("authentication did not complete: no token received")
+}
+
+// mechanismPreferences returns the one-off override, or else the profile's
+// preferred mechanisms in order
+func mechanismPreferences(cfg *config.Config, opts InteractiveOptions) []string {
+	if opts.Mechanism != "" {
+		return []string{opts.Mechanism}
 	}
+	return cfg.PreferredMechanisms
+}
+
+// matchMechanism returns the first offered mechanism matching a preference.
+// A preference matches the mechanism name (e.g. "OATH") or its display
+// text (e.g. "Mobile Authenticator"), ignoring case.
+func matchMechanism(mechanisms []Mechanism, preferences []string) (Mechanism, bool) {
+	for _, preference := range preferences {
+		preference = strings.ToLower(strings.TrimSpace(preference))
+		if preference == "" {
+			continue
+		}
+		for _, mechanism := range mechanisms {
+			if strings.ToLower(mechanism.Name) == preference ||
+				strings.Contains(strings.ToLower(mechanism.PromptSelectMech), preference) {
+				return mechanism, true
+			}
+		}
+	}
+	return Mechanism{}, false
+}
 
-	challenge := startAuthResponse.Challenges[0]
+// selectMechanism picks the preferred mechanism when it is offered and
+// otherwise lets the user pick one of the challenge's mechanisms
+func selectMechanism(challenge Challenge, reader *bufio.Reader, preferences []string) (Mechanism, error) {
 	if len(challenge.Mechanisms) == 0 {
-		return errors.New("no authentication mechanisms available")
+		return Mechanism{}, errors.New("no authentication mechanisms available")
+	}
+
+	if mechanism, ok := matchMechanism(challenge.Mechanisms, preferences); ok {
+		fmt.Printf("Using authentication mechanism: %s\n", mechanismLabel(mechanism))
+		return mechanism, nil
 	}
 
 	// Select mechanism
@@ -61,63 +135,109 @@ func AuthenticateInteractive(cfg *config.Config, configFile string) error {
 	}
 
 	fmt.Print("Select mechanism (1-" + fmt.Sprintf("%d", len(challenge.Mechanisms)) + "): ")
-	reader := bufio.NewReader(os.Stdin)
 	mechIndexStr, _ := reader.ReadString('\n')
 	mechIndexStr = strings.TrimSpace(mechIndexStr)
 
 	var mechIndex int
 	if _, err := fmt.Sscanf(mechIndexStr, "%d", &mechIndex); err != nil || mechIndex < 1 || mechIndex > len(challenge.Mechanisms) {
-		return errors.New("invalid selection")
+		return Mechanism{}, errors.New("invalid selection")
 	}
 
-	mechanism := challenge.Mechanisms[mechIndex-1]
-
-	// Get authentication answer
-	fmt.Print("Enter your response: ")
-	var answer string
-
-	// If this is a password mechanism, don't echo input
-	if strings.Contains(strings.ToLower(mechanism.Name), "password") {
-		passwordBytes, err := term.ReadPassword(int(syscall.Stdin))
-		if err != nil {
-			return fmt.Errorf("error reading password: %s", err)
-		}
-		answer = string(passwordBytes)
-		fmt.Println() // Add newline after password input
-	} else {
-		answer, _ = reader.ReadString('\n')
-		answer = strings.TrimSpace(answer)
-	}
+	return challenge.Mechanisms[mechIndex-1], nil
+}
 
-	// Advance authentication
-	advanceAuthReq := AdvanceAuthRequest{
-		SessionID:   startAuthResponse.SessionID,
-		MechanismID: mechanism.MechanismID,
-		Answer:      answer,
+// mechanismLabel returns the text shown to the user for a mechanism
+func mechanismLabel(mechanism Mechanism) string {
+	if mechanism.PromptSelectMech != "" {
+		return fmt.Sprintf("%s (%s)", mechanism.Name, mechanism.PromptSelectMech)
 	}
+	return mechanism.Name
+}
 
+// advanceAuthentication submits an answer or action for the current session
+func advanceAuthentication(client *api.Session, advanceAuthReq AdvanceAuthRequest) (*AdvanceAuthResponse, error) {
 	advanceAuthBody, err := json.Marshal(advanceAuthReq)
 	if err != nil {
-		return fmt.Errorf("error marshaling advance auth request: %s", err)
+		return nil, fmt.Errorf("error marshaling advance auth request: %s", err)
 	}
 
-	advanceAuthResp, err := api.MakeRequest(cfg, "POST", AdvanceAuthEndpoint, bytes.NewBuffer(advanceAuthBody))
+	advanceAuthResp, err := client.MakeRequest("POST", AdvanceAuthEndpoint, bytes.NewBuffer(advanceAuthBody))
 	if err != nil {
-		return fmt.Errorf("advance authentication request failed: %s", err)
+		return nil, fmt.Errorf("advance authentication request failed: %w", err)
 	}
 
 	var advanceAuthResponse AdvanceAuthResponse
 	if err := json.Unmarshal(advanceAuthResp, &advanceAuthResponse); err != nil {
-		return fmt.Errorf("error parsing advance auth response: %s", err)
+		return nil, fmt.Errorf("error parsing advance auth response: %s", err)
 	}
 
 	if !advanceAuthResponse.Success {
-		return fmt.Errorf("advance authentication failed: %s", advanceAuthResponse.ErrorMsg)
+		return nil, fmt.Errorf("advance authentication failed: %s", advanceAuthResponse.ErrorMsg)
 	}
 
-	// Save token to config
-	cfg.AuthToken = advanceAuthResponse.Token
+	return &advanceAuthResponse, nil
+}
+
+// finishLogin keeps the login's trusted-device cookies and its session
+// token. The pod the login was redirected to replaces the tenant URL only
+// when the profile sets persist_pod_redirect.
+func finishLogin(client *api.Session, cfg *config.Config, configFile, source, token string) error {
+	client.SaveCookies()
+	if cfg.PersistPodRedirect && client.BaseURL() != strings.TrimRight(cfg.TenantURL, "/") {
+		cfg.TenantURL = client.BaseURL()
+	}
+	return saveSessionToken(cfg, configFile, source, token)
+}
+
+// saveSessionToken stores an Identity session token, and the flow that issued
+// it, in the config and saves it
+func saveSessionToken(cfg *config.Config, configFile, source, token string) error {
+	cfg.AuthToken = token
+	cfg.TokenSource = source
+	cfg.TokenRefreshedAt = 0
 	cfg.TokenExpiry = time.Now().Add(1 * time.Hour).Unix() // Assuming token valid for 1 hour
 
-	return config.SaveConfig(cfg, configFile)
+	return saveConfig(cfg, configFile)
+}
+
+// startAuthentication calls StartAuthentication and follows PodFqdn redirects
+// until a pod returns the user's challenges. The rest of the login goes to
+// that pod; cfg.TenantURL only changes if finishLogin persists it.
+func startAuthentication(client *api.Session, cfg *config.Config) (*StartAuthResponse, error) {
+	startAuthReq := StartAuthRequest{
+		User:    cfg.Username,
+		Version: "1.0",
+	}
+
+	startAuthBody, err := json.Marshal(startAuthReq)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling start auth request: %s", err)
+	}
+
+	for i := 0; i <= maxPodRedirects; i++ {
+		startAuthResp, err := client.MakeRequest("POST", StartAuthEndpoint, bytes.NewBuffer(startAuthBody))
+		if err != nil {
+			return nil, fmt.Errorf("start authentication request failed: %w", err)
+		}
+
+		var startAuthResponse StartAuthResponse
+		if err := json.Unmarshal(startAuthResp, &startAuthResponse); err != nil {
+			return nil, fmt.Errorf("error parsing start auth response: %s", err)
+		}
+
+		if !startAuthResponse.Success {
+			return nil, fmt.Errorf("start authentication failed: %s", startAuthResponse.ErrorMsg)
+		}
+
+		podFqdn := startAuthResponse.Result.PodFqdn
+		if podFqdn == "" || discovery.PodURL(podFqdn) == client.BaseURL() {
+			return &startAuthResponse, nil
+		}
+
+		// The user lives on another pod, so retry there
+		fmt.Fprintf(os.Stderr, "Tenant redirected to %s\n", discovery.PodURL(podFqdn))
+		client.SetBaseURL(discovery.PodURL(podFqdn))
+	}
+
+	return nil, errors.New("too many tenant redirects")
 }
@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/infamousjoeg/summon-wpm/internal/config"
+)
+
+const clientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+// clientAssertionLifetime is how long a signed client assertion is valid
+const clientAssertionLifetime = 5 * time.Minute
+
+// newClientAssertion builds an RFC 7523 client assertion for the token endpoint
+func newClientAssertion(cfg *config.Config, audience string) (string, error) {
+	key, err := loadPrivateKey(cfg.ClientAssertionKeyFile)
+	if err != nil {
+		return "", err
+	}
+
+	jti, err := randomString(16)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss": cfg.ClientID,
+		"sub": cfg.ClientID,
+		"aud": audience,
+		"jti": jti,
+		"iat": now.Unix(),
+		"exp": now.Add(clientAssertionLifetime).Unix(),
+	}
+
+	return signJWT(key, cfg.ClientAssertionKeyID, claims)
+}
+
+// loadPrivateKey reads a PEM encoded PKCS#8, PKCS#1 or SEC 1 private key
+func loadPrivateKey(path string) (crypto.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading private key: %s", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", path)
+	}
+
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		if signer, ok := key.(crypto.Signer); ok {
+			return signer, nil
+		}
+		return nil, fmt.Errorf("unsupported private key type in %s", path)
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("unsupported private key format in %s", path)
+}
+
+// signJWT signs claims with RS256 for RSA keys or ES256 for P-256 keys
+func signJWT(key crypto.Signer, keyID string, claims map[string]interface{}) (string, error) {
+	header := map[string]string{"typ": "JWT"}
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		header["alg"] = "RS256"
+	case *ecdsa.PrivateKey:
+		if k.Curve != elliptic.P256() {
+			return "", errors.New("only P-256 ECDSA keys are supported (ES256)")
+		}
+		header["alg"] = "ES256"
+	default:
+		return "", errors.New("unsupported key type, use an RSA or P-256 ECDSA key")
+	}
+	if keyID != "" {
+		header["kid"] = keyID
+	}
+
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(headerJSON) + "." +
+		base64.RawURLEncoding.EncodeToString(claimsJSON)
+	digest := sha256.Sum256([]byte(signingInput))
+
+	var signature []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		if err != nil {
+			return "", err
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			return "", err
+		}
+		// JWS uses the fixed-width r || s encoding rather than ASN.1
+		signature = append(padBytes(r, 32), padBytes(s, 32)...)
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// padBytes left-pads the big-endian encoding of n to size bytes
+func padBytes(n *big.Int, size int) []byte {
+	b := n.Bytes()
+	if len(b) >= size {
+		return b
+	}
+	return append(make([]byte, size-len(b)), b...)
+}
@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/infamousjoeg/summon-wpm/internal/api"
+	"github.com/infamousjoeg/summon-wpm/internal/config"
+)
+
+// LogoutResult reports what Logout revoked on the tenant and removed locally
+type LogoutResult struct {
+	Revoked []string
+	Failed  map[string]error
+	Removed []string
+}
+
+// Logout revokes the profile's tokens on the tenant and removes them, along
+// with any trusted-device cookies, from the config. Revocation failures are
+// reported in the result; the local state is removed regardless.
+func Logout(cfg *config.Config, configFile string) (*LogoutResult, error) {
+	result := &LogoutResult{Failed: map[string]error{}}
+
+	if cfg.RefreshToken != "" {
+		if cfg.OAuthAppID == "" {
+			result.Failed["refresh token"] = fmt.Errorf("no OAuth application ID configured")
+		} else if err := revokeToken(cfg, cfg.RefreshToken, "refresh_token"); err != nil {
+			result.Failed["refresh token"] = err
+		} else {
+			result.Revoked = append(result.Revoked, "refresh token")
+		}
+	}
+
+	if cfg.AuthToken != "" {
+		// OAuth application tokens are revoked at the application; session
+		// tokens end the Identity session
+		var err error
+		if cfg.OAuthAppID != "" {
+			err = revokeToken(cfg, cfg.AuthToken, "access_token")
+		} else {
+			err = endSession(cfg)
+		}
+		if err != nil {
+			result.Failed["access token"] = err
+		} else {
+			result.Revoked = append(result.Revoked, "access token")
+		}
+	}
+
+	if cfg.AuthToken != "" || cfg.RefreshToken != "" {
+		result.Removed = append(result.Removed, "tokens")
+	}
+	if len(cfg.DeviceCookies) > 0 {
+		result.Removed = append(result.Removed, fmt.Sprintf("%d trusted-device cookie(s)", len(cfg.DeviceCookies)))
+	}
+
+	cfg.AuthToken = ""
+	cfg.TokenExpiry = 0
+	cfg.TokenSource = ""
+	cfg.TokenRefreshedAt = 0
+	cfg.RefreshToken = ""
+	cfg.DeviceCookies = nil
+
+	if err := config.SaveConfig(cfg, configFile); err != nil {
+		return result, fmt.Errorf("error saving config: %s", err)
+	}
+	return result, nil
+}
+
+// endSession logs the Identity session out
+func endSession(cfg *config.Config) error {
+	logoutResp, err := api.MakeAuthenticatedRequest(cfg, "POST", LogoutEndpoint, nil)
+	if err != nil {
+		return err
+	}
+
+	var response struct {
+		Success bool   `json:"success"`
+		Message string `json:"Message"`
+	}
+	if err := json.Unmarshal(logoutResp, &response); err != nil {
+		return fmt.Errorf("error parsing logout response: %s", err)
+	}
+	if !response.Success {
+		return fmt.Errorf("logout failed: %s", response.Message)
+	}
+	return nil
+}
+
+// revokeToken revokes a token at the OAuth application (RFC 7009)
+func revokeToken(cfg *config.Config, token, tokenTypeHint string) error {
+	revokeURL, err := oauthEndpoint(cfg, OAuthRevokeEndpoint)
+	if err != nil {
+		return err
+	}
+
+	data := url.Values{}
+	data.Set("token", token)
+	data.Set("token_type_hint", tokenTypeHint)
+	if cfg.OAuthClientID != "" {
+		data.Set("client_id", cfg.OAuthClientID)
+	}
+
+	req, err := http.NewRequest("POST", revokeURL, strings.NewReader(data.Encode()))
+	if err != nil {
+		return fmt.Errorf("error creating request: %s", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	// Confidential clients authenticate the revocation request
+	if cfg.OAuthClientID == "" && cfg.ClientID != "" && cfg.ClientSecret != "" {
+		clientSecret, err := config.ResolveSecret(cfg.ClientSecret)
+		if err != nil {
+			return fmt.Errorf("error resolving client secret: %s", err)
+		}
+		req.SetBasicAuth(url.QueryEscape(cfg.ClientID), url.QueryEscape(clientSecret))
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("revocation request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var oauthErr OAuthError
+		if json.NewDecoder(resp.Body).Decode(&oauthErr) == nil && oauthErr.Code != "" {
+			return &oauthErr
+		}
+		return fmt.Errorf("request failed with status: %s", resp.Status)
+	}
+	return nil
+}
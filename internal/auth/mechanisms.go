@@ -0,0 +1,270 @@
+package auth
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/term"
+
+	"github.com/infamousjoeg/summon-wpm/internal/api"
+)
+
+// Answer types sent by the tenant for each mechanism
+const (
+	AnswerTypeText         = "Text"
+	AnswerTypeStartOob     = "StartOob"
+	AnswerTypeStartTextOob = "StartTextOob"
+)
+
+// Actions accepted by advance authentication
+const (
+	ActionAnswer   = "Answer"
+	ActionStartOOB = "StartOOB"
+	ActionPoll     = "Poll"
+)
+
+const (
+	// maxNewPasswordAttempts bounds how often a mismatched confirmation is retried
+	maxNewPasswordAttempts = 3
+
+	// maxRadiusChallenges bounds the RADIUS challenge-reply round trips
+	maxRadiusChallenges = 5
+)
+
+// oobPollInterval is how often an out-of-band approval is polled
+var oobPollInterval = 2 * time.Second
+
+// oobTimeout is how long to wait for an out-of-band approval
+var oobTimeout = 2 * time.Minute
+
+// stdin is where visible answers are read from. It is a variable so tests can supply input.
+var stdin io.Reader = os.Stdin
+
+// readSecret reads a line without echoing it. It is a variable so tests can supply input.
+var readSecret = func() (string, error) {
+	passwordBytes, err := term.ReadPassword(int(syscall.Stdin))
+	fmt.Println() // Add newline after password input
+	return string(passwordBytes), err
+}
+
+// loginSession carries the state shared by the mechanism handlers
+type loginSession struct {
+	client    *api.Session
+	reader    *bufio.Reader
+	sessionID string
+}
+
+// advance submits an action for the mechanism in this session
+func (s *loginSession) advance(mechanism Mechanism, action, answer string) (*AdvanceAuthResponse, error) {
+	return advanceAuthentication(s.client, AdvanceAuthRequest{
+		SessionID:   s.sessionID,
+		MechanismID: mechanism.MechanismID,
+		Action:      action,
+		Answer:      answer,
+	})
+}
+
+// prompt shows text and reads a line, hiding the input for secrets
+func (s *loginSession) prompt(text string, hidden bool) (string, error) {
+	fmt.Print(text)
+	if hidden {
+		answer, err := readSecret()
+		if err != nil {
+			return "", fmt.Errorf("error reading response: %s", err)
+		}
+		return answer, nil
+	}
+
+	answer, err := s.reader.ReadString('\n')
+	if err != nil && answer == "" {
+		return "", fmt.Errorf("error reading response: %s", err)
+	}
+	return strings.TrimSpace(answer), nil
+}
+
+// mechanismHandler answers one mechanism and returns the tenant's response
+type mechanismHandler func(s *loginSession, mechanism Mechanism) (*AdvanceAuthResponse, error)
+
+// mechanismHandlers are keyed on the mechanism Name
+var mechanismHandlers = map[string]mechanismHandler{
+	"UP":             answerPassword,
+	"SQ":             answerSecurityQuestion,
+	"OATH":           answerCode,
+	"SMS":            answerTextOob,
+	"EMAIL":          answerTextOob,
+	"RADIUS":         answerRadius,
+	"RESET":          answerNewPassword,
+	"PASSWORDRESET":  answerNewPassword,
+	"PASSWORDEXPIRY": answerNewPassword,
+}
+
+// handlerFor picks the handler by mechanism Name, falling back on the AnswerType
+func handlerFor(mechanism Mechanism) mechanismHandler {
+	if handler, ok := mechanismHandlers[strings.ToUpper(mechanism.Name)]; ok {
+		return handler
+	}
+
+	switch mechanism.AnswerType {
+	case AnswerTypeStartOob:
+		return answerOob
+	case AnswerTypeStartTextOob:
+		return answerTextOob
+	}
+
+	if strings.Contains(strings.ToLower(mechanism.Name), "password") {
+		return answerPassword
+	}
+	return answerCode
+}
+
+// promptText returns the tenant's prompt for the mechanism, or fallback
+func promptText(mechanism Mechanism, fallback string) string {
+	if mechanism.PromptMechChosen != "" {
+		return strings.TrimRight(mechanism.PromptMechChosen, ": ") + ": "
+	}
+	return fallback
+}
+
+// answerPassword reads a password without echo
+func answerPassword(s *loginSession, mechanism Mechanism) (*AdvanceAuthResponse, error) {
+	answer, err := s.prompt(promptText(mechanism, "Password: "), true)
+	if err != nil {
+		return nil, err
+	}
+	return s.advance(mechanism, ActionAnswer, answer)
+}
+
+// answerSecurityQuestion shows the question and reads the answer without echo
+func answerSecurityQuestion(s *loginSession, mechanism Mechanism) (*AdvanceAuthResponse, error) {
+	question := mechanism.Question
+	if question == "" {
+		question = strings.TrimRight(promptText(mechanism, "Security question"), ": ")
+	}
+	answer, err := s.prompt(question+": ", true)
+	if err != nil {
+		return nil, err
+	}
+	return s.advance(mechanism, ActionAnswer, answer)
+}
+
+// answerCode reads a visible one-time code
+func answerCode(s *loginSession, mechanism Mechanism) (*AdvanceAuthResponse, error) {
+	answer, err := s.prompt(promptText(mechanism, "Enter your response: "), false)
+	if err != nil {
+		return nil, err
+	}
+	return s.advance(mechanism, ActionAnswer, answer)
+}
+
+// answerTextOob has the tenant send a code by SMS or email and reads it back
+func answerTextOob(s *loginSession, mechanism Mechanism) (*AdvanceAuthResponse, error) {
+	if _, err := s.advance(mechanism, ActionStartOOB, ""); err != nil {
+		return nil, err
+	}
+
+	if address := mechanism.deliveryAddress(); address != "" {
+		fmt.Printf("A code was sent to %s\n", address)
+	} else {
+		fmt.Println("A code was sent to you")
+	}
+
+	answer, err := s.prompt("Enter the code: ", false)
+	if err != nil {
+		return nil, err
+	}
+	return s.advance(mechanism, ActionAnswer, answer)
+}
+
+// answerOob starts an out-of-band approval (push notification, phone call)
+// and polls until the user approves it on their device
+func answerOob(s *loginSession, mechanism Mechanism) (*AdvanceAuthResponse, error) {
+	response, err := s.advance(mechanism, ActionStartOOB, "")
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println(strings.TrimRight(promptText(mechanism, "Approve the login on your device"), ": "))
+	fmt.Println("Waiting for approval...")
+
+	deadline := time.Now().Add(oobTimeout)
+	for response.Result.Summary == SummaryOobPending {
+		if time.Now().After(deadline) {
+			return nil, errors.New("timed out waiting for approval")
+		}
+		pollSleep(oobPollInterval)
+
+		response, err = s.advance(mechanism, ActionPoll, "")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return response, nil
+}
+
+// answerRadius answers a RADIUS mechanism, replying to any follow-up
+// challenges the RADIUS server sends (e.g. next token code)
+func answerRadius(s *loginSession, mechanism Mechanism) (*AdvanceAuthResponse, error) {
+	answer, err := s.prompt(promptText(mechanism, "RADIUS passcode: "), true)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := s.advance(mechanism, ActionAnswer, answer)
+	for i := 0; err == nil && response.Result.Summary == SummaryRadiusChallenge; i++ {
+		if i >= maxRadiusChallenges {
+			return nil, errors.New("too many RADIUS challenges")
+		}
+
+		message := response.Result.Message
+		if message == "" {
+			message = "RADIUS challenge"
+		}
+		answer, err = s.prompt(strings.TrimRight(message, ": ")+": ", true)
+		if err != nil {
+			return nil, err
+		}
+		response, err = s.advance(mechanism, ActionAnswer, answer)
+	}
+
+	return response, err
+}
+
+// answerNewPassword asks twice for a new password until both entries match
+func answerNewPassword(s *loginSession, mechanism Mechanism) (*AdvanceAuthResponse, error) {
+	if mechanism.PromptMechChosen != "" {
+		fmt.Println(mechanism.PromptMechChosen)
+	} else {
+		fmt.Println("Your password has expired or must be reset.")
+	}
+
+	for attempt := 0; attempt < maxNewPasswordAttempts; attempt++ {
+		password, err := s.prompt("New password: ", true)
+		if err != nil {
+			return nil, err
+		}
+
+		confirmation, err := s.prompt("Confirm new password: ", true)
+		if err != nil {
+			return nil, err
+		}
+
+		if password == "" {
+			fmt.Println("Password cannot be empty.")
+			continue
+		}
+		if password != confirmation {
+			fmt.Println("Passwords do not match, try again.")
+			continue
+		}
+		return s.advance(mechanism, ActionAnswer, password)
+	}
+
+	return nil, errors.New("new password was not confirmed")
+}
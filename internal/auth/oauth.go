@@ -0,0 +1,246 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/infamousjoeg/summon-wpm/internal/api"
+	"github.com/infamousjoeg/summon-wpm/internal/config"
+)
+
+// browserLoginScope is requested for the authorization code flow
+const browserLoginScope = "openid profile"
+
+// OAuthError represents an RFC 6749 error response from a token endpoint
+type OAuthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *OAuthError) Error() string {
+	if e.Description != "" {
+		return fmt.Sprintf("%s: %s", e.Code, e.Description)
+	}
+	return e.Code
+}
+
+// oauthEndpoint returns the per-application OAuth2 endpoint for the tenant
+func oauthEndpoint(cfg *config.Config, path string) (string, error) {
+	if cfg.OAuthAppID == "" {
+		return "", errors.New("no OAuth application ID configured (set oauth_app_id)")
+	}
+	return strings.TrimRight(cfg.TenantURL, "/") + path + url.PathEscape(cfg.OAuthAppID), nil
+}
+
+// tokenURL returns the token endpoint for service authentication, which is the
+// application's own endpoint when an OAuth application is configured
+func tokenURL(cfg *config.Config) string {
+	if cfg.OAuthAppID != "" {
+		endpoint, _ := oauthEndpoint(cfg, OAuthTokenEndpoint)
+		return endpoint
+	}
+	return strings.TrimRight(cfg.TenantURL, "/") + TokenEndpoint
+}
+
+// scopeParam returns the configured scopes, or fallback when none are set
+func scopeParam(cfg *config.Config, fallback string) string {
+	if len(cfg.Scopes) > 0 {
+		return strings.Join(cfg.Scopes, " ")
+	}
+	return fallback
+}
+
+// requestToken posts a form to a token endpoint and parses the token response
+func requestToken(tokenURL string, data url.Values) (*TokenResponse, error) {
+	return requestTokenWithBasicAuth(tokenURL, data, "", "")
+}
+
+// requestTokenWithBasicAuth is requestToken using HTTP Basic client
+// authentication (client_secret_basic) when clientID is not empty
+func requestTokenWithBasicAuth(tokenURL string, data url.Values, clientID, clientSecret string) (*TokenResponse, error) {
+	// Create request
+	req, err := http.NewRequest("POST", tokenURL, strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %s", err)
+	}
+
+	if clientID != "" {
+		// RFC 6749 section 2.3.1 form-encodes the credentials before Basic encoding
+		req.SetBasicAuth(url.QueryEscape(clientID), url.QueryEscape(clientSecret))
+	}
+
+	// Set proper headers for form data
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	// Make request
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Read response
+	tokenResp, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response: %s", err)
+	}
+
+	// Check response, preferring the OAuth error code when there is one
+	if resp.StatusCode != http.StatusOK {
+		var oauthErr OAuthError
+		if json.Unmarshal(tokenResp, &oauthErr) == nil && oauthErr.Code != "" {
+			return nil, &oauthErr
+		}
+		return nil, &api.StatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+
+	// Parse token response
+	var tokenResponse TokenResponse
+	if err := json.Unmarshal(tokenResp, &tokenResponse); err != nil {
+		return nil, fmt.Errorf("error parsing token response: %s", err)
+	}
+
+	if tokenResponse.AccessToken == "" {
+		return nil, errors.New("no access token received")
+	}
+
+	return &tokenResponse, nil
+}
+
+// defaultTokenLifetime is assumed when a token response has no expires_in
+const defaultTokenLifetime = 1 * time.Hour
+
+// saveConfig saves the config after a login. An empty configFile keeps the
+// tokens in memory only, as the agent does.
+func saveConfig(cfg *config.Config, configFile string) error {
+	if configFile == "" {
+		return nil
+	}
+	return config.SaveConfig(cfg, configFile)
+}
+
+// saveTokenResponse stores the tokens, and the flow that issued them, in the
+// config and saves it
+func saveTokenResponse(cfg *config.Config, configFile, source string, tokenResponse *TokenResponse) error {
+	cfg.AuthToken = tokenResponse.AccessToken
+	if source == TokenSourceRefresh {
+		cfg.TokenRefreshedAt = time.Now().Unix()
+	} else {
+		cfg.TokenSource = source
+		cfg.TokenRefreshedAt = 0
+	}
+	expiryDuration := defaultTokenLifetime
+	if tokenResponse.ExpiresIn > 0 {
+		expiryDuration = time.Duration(tokenResponse.ExpiresIn) * time.Second
+	}
+	cfg.TokenExpiry = time.Now().Add(expiryDuration).Unix()
+	if tokenResponse.RefreshToken != "" {
+		cfg.RefreshToken = tokenResponse.RefreshToken
+	}
+
+	return saveConfig(cfg, configFile)
+}
+
+// AuthenticateWithBrowser logs a human user in with the OAuth2 authorization
+// code flow and PKCE against the tenant OAuth application. The tenant drives
+// the whole MFA experience in the browser and the code is caught on a
+// loopback redirect.
+func AuthenticateWithBrowser(cfg *config.Config, configFile string) error {
+	authorizeURL, err := oauthEndpoint(cfg, OAuthAuthorizeEndpoint)
+	if err != nil {
+		return err
+	}
+	tokenURL, err := oauthEndpoint(cfg, OAuthTokenEndpoint)
+	if err != nil {
+		return err
+	}
+	if cfg.OAuthClientID == "" {
+		return errors.New("no OAuth client ID configured (set oauth_client_id)")
+	}
+
+	state, err := randomString(24)
+	if err != nil {
+		return fmt.Errorf("error generating state: %s", err)
+	}
+
+	callback, err := newLoopbackServer(state)
+	if err != nil {
+		return err
+	}
+	defer callback.Close()
+	verifier, err := randomString(32)
+	if err != nil {
+		return fmt.Errorf("error generating code verifier: %s", err)
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", cfg.OAuthClientID)
+	query.Set("redirect_uri", callback.RedirectURI)
+	query.Set("scope", scopeParam(cfg, browserLoginScope))
+	query.Set("state", state)
+	query.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	query.Set("code_challenge_method", "S256")
+
+	openBrowserTo(authorizeURL + "?" + query.Encode())
+
+	params, err := callback.wait()
+	if err != nil {
+		return err
+	}
+
+	code := params.Get("code")
+	if code == "" {
+		return errors.New("no authorization code received")
+	}
+
+	// Exchange the code for tokens
+	data := url.Values{}
+	data.Set("grant_type", "authorization_code")
+	data.Set("code", code)
+	data.Set("redirect_uri", callback.RedirectURI)
+	data.Set("client_id", cfg.OAuthClientID)
+	data.Set("code_verifier", verifier)
+
+	tokenResponse, err := requestToken(tokenURL, data)
+	if err != nil {
+		return err
+	}
+
+	return saveTokenResponse(cfg, configFile, TokenSourceBrowser, tokenResponse)
+}
+
+// RefreshAccessToken uses the stored refresh token to get a new access token
+func RefreshAccessToken(cfg *config.Config, configFile string) error {
+	if cfg.RefreshToken == "" {
+		return errors.New("no refresh token available")
+	}
+
+	tokenURL, err := oauthEndpoint(cfg, OAuthTokenEndpoint)
+	if err != nil {
+		return err
+	}
+
+	data := url.Values{}
+	data.Set("grant_type", "refresh_token")
+	data.Set("refresh_token", cfg.RefreshToken)
+	data.Set("client_id", cfg.OAuthClientID)
+
+	tokenResponse, err := requestToken(tokenURL, data)
+	if err != nil {
+		return err
+	}
+
+	return saveTokenResponse(cfg, configFile, TokenSourceRefresh, tokenResponse)
+}
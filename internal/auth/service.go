@@ -4,12 +4,9 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
-	"net/http"
 	"net/url"
 	"os"
 	"strings"
-	"time"
 
 	"github.com/infamousjoeg/summon-wpm/internal/api"
 	"github.com/infamousjoeg/summon-wpm/internal/config"
@@ -17,64 +14,75 @@ import (
 
 // AuthenticateWithClientCredentials performs non-interactive authentication using client credentials
 func AuthenticateWithClientCredentials(cfg *config.Config, configFile string) error {
-	// Create form data
-	data := url.Values{}
-	data.Set("grant_type", "client_credentials")
-	data.Set("client_id", cfg.ClientID)
-	data.Set("client_secret", cfg.ClientSecret)
+	endpoint := tokenURL(cfg)
 
-	// Create request
-	req, err := http.NewRequest("POST",
-		strings.TrimRight(cfg.TenantURL, "/")+TokenEndpoint,
-		strings.NewReader(data.Encode()))
-
-	if err != nil {
-		return fmt.Errorf("error creating request: %s", err)
+	tokenReq := TokenRequest{
+		GrantType: "client_credentials",
+		ClientID:  cfg.ClientID,
+		Scope:     scopeParam(cfg, ""),
+		Audience:  cfg.Audience,
 	}
 
-	// Set proper headers for form data
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	req.Header.Set("Accept", "application/json")
-
-	// Make request
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("token request failed: %s", err)
+	method := cfg.ClientAuthMethod
+	if method == "" {
+		method = ClientAuthSecretPost
+		if cfg.ClientAssertionKeyFile != "" {
+			method = ClientAuthPrivateKeyJWT
+		}
 	}
-	defer resp.Body.Close()
 
-	// Check response
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("request failed with status: %s", resp.Status)
+	// The secret may be a file:, env: or exec: reference, resolved only now
+	var clientSecret string
+	if method == ClientAuthSecretPost || method == ClientAuthSecretBasic {
+		var err error
+		clientSecret, err = config.ResolveSecret(cfg.ClientSecret)
+		if err != nil {
+			return fmt.Errorf("error resolving client secret: %s", err)
+		}
 	}
 
-	// Read response
-	tokenResp, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("error reading response: %s", err)
+	var basicID, basicSecret string
+	switch method {
+	case ClientAuthSecretPost:
+		tokenReq.ClientSecret = clientSecret
+	case ClientAuthSecretBasic:
+		// Credentials go in the Authorization header instead of the body
+		tokenReq.ClientID = ""
+		basicID, basicSecret = cfg.ClientID, clientSecret
+	case ClientAuthPrivateKeyJWT:
+		// Prove possession of the key instead of sending a secret
+		assertion, err := newClientAssertion(cfg, endpoint)
+		if err != nil {
+			return fmt.Errorf("error creating client assertion: %s", err)
+		}
+		tokenReq.ClientAssertionType = clientAssertionType
+		tokenReq.ClientAssertion = assertion
+	default:
+		return fmt.Errorf("unknown client_auth_method %q", cfg.ClientAuthMethod)
 	}
 
-	// Parse token response
-	var tokenResponse TokenResponse
-	if err := json.Unmarshal(tokenResp, &tokenResponse); err != nil {
-		return fmt.Errorf("error parsing token response: %s", err)
+	tokenResponse, err := requestTokenWithBasicAuth(endpoint, tokenReq.Form(), basicID, basicSecret)
+	if err != nil {
+		return err
 	}
 
-	if tokenResponse.AccessToken == "" {
-		return errors.New("no access token received")
+	source := AuthModeClientCredentials
+	if method == ClientAuthPrivateKeyJWT {
+		source = AuthModePrivateKeyJWT
 	}
 
 	// Save token to config
-	cfg.AuthToken = tokenResponse.AccessToken
-	expiryDuration := time.Duration(tokenResponse.ExpiresIn) * time.Second
-	cfg.TokenExpiry = time.Now().Add(expiryDuration).Unix()
-
-	return config.SaveConfig(cfg, configFile)
+	return saveTokenResponse(cfg, configFile, source, tokenResponse)
 }
 
 // GetAppCredentials retrieves application credentials from CyberArk Identity
 func GetAppCredentials(cfg *config.Config, appID string) (string, error) {
+	return GetAppCredentialField(cfg, appID, "")
+}
+
+// GetAppCredentialField retrieves one field of an application's credentials,
+// e.g. "Username". An empty field selects the password.
+func GetAppCredentialField(cfg *config.Config, appID, field string) (string, error) {
 	// Create the endpoint URL with query parameter
 	endpoint := fmt.Sprintf("%s?appkey=%s", GetAppCredsEndpoint, url.QueryEscape(appID))
 
@@ -84,7 +92,7 @@ func GetAppCredentials(cfg *config.Config, appID string) (string, error) {
 	// Make request with empty body since we're using query parameters
 	appCredResp, err := api.MakeAuthenticatedRequest(cfg, "POST", endpoint, nil)
 	if err != nil {
-		return "", fmt.Errorf("app credentials request failed: %s", err)
+		return "", fmt.Errorf("app credentials request failed: %w", err)
 	}
 
 	// Print full response for debugging
@@ -106,6 +114,16 @@ func GetAppCredentials(cfg *config.Config, appID string) (string, error) {
 		return "", errors.New("empty result from API - credential not found or access denied")
 	}
 
+	// A selected field is matched by name, ignoring case
+	if field != "" {
+		for key, value := range appCredResponse.Result {
+			if strings.EqualFold(key, field) {
+				return fmt.Sprint(value), nil
+			}
+		}
+		return "", fmt.Errorf("field %q not found in result", field)
+	}
+
 	// Extract the password from the Result map
 	for _, possibleKey := range []string{"Password", "password", "secret", "value", "credential"} {
 		if val, ok := appCredResponse.Result[possibleKey].(string); ok {
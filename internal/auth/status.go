@@ -0,0 +1,156 @@
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/infamousjoeg/summon-wpm/internal/api"
+	"github.com/infamousjoeg/summon-wpm/internal/config"
+)
+
+// Status describes a profile's session without revealing its tokens
+type Status struct {
+	Profile         string     `json:"profile"`
+	TenantURL       string     `json:"tenant_url"`
+	Username        string     `json:"username,omitempty"`
+	AuthMode        string     `json:"auth_mode"`
+	TokenSource     string     `json:"token_source,omitempty"`
+	RefreshedAt     *time.Time `json:"refreshed_at,omitempty"`
+	HasToken        bool       `json:"has_token"`
+	HasRefreshToken bool       `json:"has_refresh_token"`
+	ExpiresAt       *time.Time `json:"expires_at,omitempty"`
+	ExpiresIn       int64      `json:"expires_in,omitempty"`
+	NeedsAuth       bool       `json:"needs_authentication"`
+	// Renewable marks an expired token the next lookup renews without a
+	// login, from the refresh token or the service credentials
+	Renewable     bool      `json:"renewable,omitempty"`
+	Reason        string    `json:"reason,omitempty"`
+	Identity      *Identity `json:"identity,omitempty"`
+	IdentityError string    `json:"identity_error,omitempty"`
+}
+
+// Identity is the user the tenant sees for the current token
+type Identity struct {
+	User        string `json:"User"`
+	UserUUID    string `json:"UserUuid"`
+	DisplayName string `json:"DisplayName,omitempty"`
+	TenantID    string `json:"TenantId"`
+}
+
+// tokenClaims are the JWT claims read for status. The signature is not
+// verified; the tenant does that when the token is used.
+type tokenClaims struct {
+	ExpiresAt int64 `json:"exp"`
+}
+
+// GetStatus reports the profile's session, asking the tenant who the token
+// belongs to when there is a usable token
+func GetStatus(cfg *config.Config, profile string) *Status {
+	status := &Status{
+		Profile:         profile,
+		TenantURL:       cfg.TenantURL,
+		Username:        cfg.Username,
+		AuthMode:        AuthMode(cfg),
+		TokenSource:     cfg.TokenSource,
+		HasToken:        cfg.AuthToken != "",
+		HasRefreshToken: cfg.RefreshToken != "",
+	}
+
+	needsAuth := NeedsAuthentication(cfg)
+	if needsAuth && (cfg.RefreshToken != "" || HasServiceCredentials(cfg)) {
+		status.Renewable = true
+	} else {
+		status.NeedsAuth = needsAuth
+	}
+
+	if cfg.TokenRefreshedAt > 0 {
+		refreshedAt := time.Unix(cfg.TokenRefreshedAt, 0)
+		status.RefreshedAt = &refreshedAt
+	}
+
+	// The token's own expiry is authoritative; the stored one may be a guess
+	expiry := cfg.TokenExpiry
+	if claims, err := parseTokenClaims(cfg.AuthToken); err == nil && claims.ExpiresAt > 0 {
+		expiry = claims.ExpiresAt
+	}
+	expired := false
+	if expiry > 0 {
+		expiresAt := time.Unix(expiry, 0)
+		status.ExpiresAt = &expiresAt
+		if remaining := time.Until(expiresAt); remaining > 0 {
+			status.ExpiresIn = int64(remaining.Seconds())
+		} else {
+			expired = true
+		}
+	}
+
+	// Explain why the next credential lookup will or will not log in again
+	switch {
+	case cfg.AuthToken == "":
+		status.Reason = "no token stored"
+	case status.Renewable && cfg.RefreshToken != "":
+		status.Reason = "stored token has expired; it is renewed with the refresh token"
+	case status.Renewable:
+		status.Reason = "stored token has expired; it is renewed with the service credentials"
+	case status.NeedsAuth:
+		status.Reason = "stored token has expired"
+	case expired:
+		status.Reason = "token claims show it has expired; it is replaced once the tenant rejects it"
+	}
+
+	if status.HasToken && !expired && !needsAuth && cfg.TenantURL != "" {
+		identity, err := WhoAmI(cfg)
+		if err != nil {
+			status.IdentityError = err.Error()
+		} else {
+			status.Identity = identity
+		}
+	}
+
+	return status
+}
+
+// WhoAmI asks the tenant which user the current token belongs to
+func WhoAmI(cfg *config.Config) (*Identity, error) {
+	whoamiResp, err := api.MakeAuthenticatedRequest(cfg, "POST", WhoAmIEndpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("whoami request failed: %w", err)
+	}
+
+	var response struct {
+		Success bool     `json:"success"`
+		Result  Identity `json:"Result"`
+		Message string   `json:"Message"`
+	}
+	if err := json.Unmarshal(whoamiResp, &response); err != nil {
+		return nil, fmt.Errorf("error parsing whoami response: %s", err)
+	}
+	if !response.Success {
+		return nil, fmt.Errorf("whoami failed: %s", response.Message)
+	}
+
+	return &response.Result, nil
+}
+
+// parseTokenClaims decodes the claims of a JWT without verifying it
+func parseTokenClaims(token string) (*tokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("token is not a JWT")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, fmt.Errorf("error decoding token claims: %s", err)
+	}
+
+	var claims tokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("error parsing token claims: %s", err)
+	}
+	return &claims, nil
+}
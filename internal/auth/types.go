@@ -1,7 +1,9 @@
 package auth
 
 import (
+	"encoding/json"
 	"errors"
+	"net/url"
 	"os"
 	"time"
 
@@ -11,10 +13,19 @@ import (
 )
 
 const (
-	StartAuthEndpoint   = "/Security/StartAuthentication"
-	AdvanceAuthEndpoint = "/Security/AdvanceAuthentication"
-	TokenEndpoint       = "/oauth2/platformtoken"
-	GetAppCredsEndpoint = "/UPRest/GetMCFA"
+	StartAuthEndpoint      = "/Security/StartAuthentication"
+	AdvanceAuthEndpoint    = "/Security/AdvanceAuthentication"
+	TokenEndpoint          = "/oauth2/platformtoken"
+	OAuthAuthorizeEndpoint = "/OAuth2/Authorize/"
+	OAuthTokenEndpoint     = "/OAuth2/Token/"
+	OAuthDeviceEndpoint    = "/OAuth2/Device/"
+	GetAppCredsEndpoint    = "/UPRest/GetMCFA"
+	CertAuthEndpoint       = "/Security/CertAuth"
+	LogoutEndpoint         = "/Security/Logout"
+	OAuthRevokeEndpoint    = "/OAuth2/Revoke/"
+	WhoAmIEndpoint         = "/Security/whoami"
+	GetUPDataEndpoint      = "/UPRest/GetUPData"
+	OobAuthStatusEndpoint  = "/Security/OobAuthStatus"
 )
 
 // StartAuthRequest represents the request body for starting authentication
@@ -25,12 +36,19 @@ type StartAuthRequest struct {
 
 // StartAuthResponse represents the response from start authentication
 type StartAuthResponse struct {
-	Success    bool        `json:"success"`
-	Result     interface{} `json:"Result"`
-	SessionID  string      `json:"SessionId"`
-	Challenges []Challenge `json:"Challenges"`
-	ErrorID    int         `json:"ErrorId"`
-	ErrorMsg   string      `json:"ErrorMsg"`
+	Success    bool            `json:"success"`
+	Result     StartAuthResult `json:"Result"`
+	SessionID  string          `json:"SessionId"`
+	Challenges []Challenge     `json:"Challenges"`
+	ErrorID    int             `json:"ErrorId"`
+	ErrorMsg   string          `json:"ErrorMsg"`
+}
+
+// StartAuthResult holds the redirect hints returned by start authentication
+type StartAuthResult struct {
+	PodFqdn           string `json:"PodFqdn"`
+	IdpRedirectURL    string `json:"IdpRedirectUrl"`
+	IdpLoginSessionID string `json:"IdpLoginSessionId"`
 }
 
 // Challenge represents an authentication challenge
@@ -42,33 +60,136 @@ type Challenge struct {
 
 // Mechanism represents an authentication mechanism
 type Mechanism struct {
-	MechanismID      string `json:"MechanismId"`
-	Name             string `json:"Name"`
-	PromptSelectMech string `json:"PromptSelectMech"`
+	MechanismID          string `json:"MechanismId"`
+	Name                 string `json:"Name"`
+	AnswerType           string `json:"AnswerType"`
+	PromptSelectMech     string `json:"PromptSelectMech"`
+	PromptMechChosen     string `json:"PromptMechChosen"`
+	Question             string `json:"Question,omitempty"`
+	PartialDeviceAddress string `json:"PartialDeviceAddress,omitempty"`
+	PartialAddress       string `json:"PartialAddress,omitempty"`
+}
+
+// deliveryAddress returns the masked phone number or email a code was sent to
+func (m Mechanism) deliveryAddress() string {
+	if m.PartialDeviceAddress != "" {
+		return m.PartialDeviceAddress
+	}
+	return m.PartialAddress
 }
 
 // AdvanceAuthRequest represents the request body for advancing authentication
 type AdvanceAuthRequest struct {
 	SessionID   string `json:"SessionId"`
 	MechanismID string `json:"MechanismId"`
+	Action      string `json:"Action,omitempty"`
 	Answer      string `json:"Answer"`
 }
 
+// Summaries returned in the advance authentication result
+const (
+	SummaryLoginSuccess       = "LoginSuccess"
+	SummaryStartNextChallenge = "StartNextChallenge"
+	SummaryNewPackage         = "NewPackage"
+	SummaryOobPending         = "OobPending"
+	SummaryRadiusChallenge    = "RadiusChallenge"
+)
+
 // AdvanceAuthResponse represents the response from advance authentication
 type AdvanceAuthResponse struct {
-	Success  bool   `json:"success"`
-	Result   string `json:"Result"`
-	Token    string `json:"Token"`
+	Success  bool              `json:"success"`
+	Result   AdvanceAuthResult `json:"Result"`
+	Token    string            `json:"Token"`
+	ErrorID  int               `json:"ErrorId"`
+	ErrorMsg string            `json:"ErrorMsg"`
+}
+
+// AdvanceAuthResult holds the outcome of answering a challenge
+type AdvanceAuthResult struct {
+	Summary    string      `json:"Summary"`
+	Token      string      `json:"Token"`
+	Message    string      `json:"Message,omitempty"`
+	Challenges []Challenge `json:"Challenges"`
+}
+
+// UnmarshalJSON accepts either a result object or a bare summary string
+func (r *AdvanceAuthResult) UnmarshalJSON(data []byte) error {
+	var summary string
+	if err := json.Unmarshal(data, &summary); err == nil {
+		r.Summary = summary
+		return nil
+	}
+
+	type plainResult AdvanceAuthResult
+	return json.Unmarshal(data, (*plainResult)(r))
+}
+
+// SessionToken returns the session token from the result or the top level
+func (r *AdvanceAuthResponse) SessionToken() string {
+	if r.Result.Token != "" {
+		return r.Result.Token
+	}
+	return r.Token
+}
+
+// OobAuthStatusRequest polls the state of an IdP login session
+type OobAuthStatusRequest struct {
+	SessionID string `json:"SessionId"`
+}
+
+// IdP login states returned by OobAuthStatus
+const (
+	OobStateSuccess = "Success"
+	OobStatePending = "Pending"
+)
+
+// OobAuthStatusResponse represents the response from OobAuthStatus
+type OobAuthStatusResponse struct {
+	Success bool `json:"success"`
+	Result  struct {
+		State string `json:"State"`
+		Token string `json:"Token"`
+	} `json:"Result"`
 	ErrorID  int    `json:"ErrorId"`
 	ErrorMsg string `json:"ErrorMsg"`
 }
 
+// Client authentication methods accepted in client_auth_method
+const (
+	ClientAuthSecretPost    = "client_secret_post"
+	ClientAuthSecretBasic   = "client_secret_basic"
+	ClientAuthPrivateKeyJWT = "private_key_jwt"
+)
+
 // TokenRequest represents the request body for token endpoint
 type TokenRequest struct {
-	GrantType    string `json:"grant_type"`
-	ClientID     string `json:"client_id"`
-	ClientSecret string `json:"client_secret"`
-	Scope        string `json:"scope,omitempty"`
+	GrantType           string `json:"grant_type"`
+	ClientID            string `json:"client_id"`
+	ClientSecret        string `json:"client_secret"`
+	ClientAssertionType string `json:"client_assertion_type,omitempty"`
+	ClientAssertion     string `json:"client_assertion,omitempty"`
+	Scope               string `json:"scope,omitempty"`
+	Audience            string `json:"audience,omitempty"`
+}
+
+// Form encodes the request as the form body sent to the token endpoint,
+// leaving out empty parameters
+func (r TokenRequest) Form() url.Values {
+	data := url.Values{}
+	for key, value := range map[string]string{
+		"grant_type":            r.GrantType,
+		"client_id":             r.ClientID,
+		"client_secret":         r.ClientSecret,
+		"client_assertion_type": r.ClientAssertionType,
+		"client_assertion":      r.ClientAssertion,
+		"scope":                 r.Scope,
+		"audience":              r.Audience,
+	} {
+		if value != "" {
+			data.Set(key, value)
+		}
+	}
+	return data
 }
 
 // TokenResponse represents the response from token endpoint
@@ -80,6 +201,16 @@ type TokenResponse struct {
 	Scope        string `json:"scope,omitempty"`
 }
 
+// DeviceAuthResponse represents the response from the device authorization endpoint
+type DeviceAuthResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete,omitempty"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval,omitempty"`
+}
+
 // AppCredRequest represents the request body for getting app credentials
 type AppCredRequest struct {
 	AppID string `json:"AppID"`
@@ -96,17 +227,74 @@ func IsInteractive() bool {
 	return term.IsTerminal(int(os.Stdin.Fd()))
 }
 
-// Authenticate handles authentication to CyberArk Identity
-func Authenticate(cfg *config.Config, configFile string, forceInteractive bool) error {
-	if cfg.ClientID != "" && cfg.ClientSecret != "" && !forceInteractive {
+// Authentication modes returned by AuthMode
+const (
+	AuthModeInteractive       = "interactive"
+	AuthModeClientCredentials = "client_credentials"
+	AuthModePrivateKeyJWT     = "private_key_jwt"
+	AuthModeWorkloadIdentity  = "workload_identity"
+	AuthModeCertificate       = "certificate"
+)
+
+// Token sources recorded besides the authentication modes
+const (
+	TokenSourceFederated = "federated"
+	TokenSourceBrowser   = "browser"
+	TokenSourceDevice    = "device"
+)
+
+// TokenSourceRefresh marks a token renewed with the refresh token. It is
+// recorded in TokenRefreshedAt instead of replacing the token source.
+const TokenSourceRefresh = "refresh_token"
+
+// AuthMode returns how the profile authenticates without a user present,
+// or AuthModeInteractive when it has no service credentials
+func AuthMode(cfg *config.Config) string {
+	switch {
+	case cfg.SubjectTokenSource != "":
+		return AuthModeWorkloadIdentity
+	case cfg.ClientCertFile != "":
+		return AuthModeCertificate
+	case cfg.ClientID != "" && cfg.ClientAssertionKeyFile != "":
+		return AuthModePrivateKeyJWT
+	case cfg.ClientID != "" && cfg.ClientSecret != "":
+		return AuthModeClientCredentials
+	default:
+		return AuthModeInteractive
+	}
+}
+
+// HasServiceCredentials reports whether the profile can authenticate non-interactively
+func HasServiceCredentials(cfg *config.Config) bool {
+	return AuthMode(cfg) != AuthModeInteractive
+}
+
+// AuthenticateService authenticates with the profile's non-interactive credentials
+func AuthenticateService(cfg *config.Config, configFile string) error {
+	switch AuthMode(cfg) {
+	case AuthModeWorkloadIdentity:
+		return AuthenticateWithWorkloadIdentity(cfg, configFile)
+	case AuthModeCertificate:
+		return AuthenticateWithCertificate(cfg, configFile)
+	case AuthModeClientCredentials, AuthModePrivateKeyJWT:
 		return AuthenticateWithClientCredentials(cfg, configFile)
+	default:
+		return errors.New("no service credentials configured")
+	}
+}
+
+// Authenticate handles authentication to CyberArk Identity. opts applies
+// when the login is interactive.
+func Authenticate(cfg *config.Config, configFile string, forceInteractive bool, opts InteractiveOptions) error {
+	if HasServiceCredentials(cfg) && !forceInteractive {
+		return AuthenticateService(cfg, configFile)
 	}
 
 	if !IsInteractive() {
 		return errors.New("cannot perform interactive authentication in non-interactive mode")
 	}
 
-	return AuthenticateInteractive(cfg, configFile)
+	return AuthenticateInteractive(cfg, configFile, opts)
 }
 
 // NeedsAuthentication checks if authentication is needed
@@ -0,0 +1,171 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/infamousjoeg/summon-wpm/internal/config"
+)
+
+const (
+	tokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+	jwtBearerGrantType     = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+	jwtTokenType           = "urn:ietf:params:oauth:token-type:jwt"
+	accessTokenType        = "urn:ietf:params:oauth:token-type:access_token"
+
+	// Subject token grants accepted in subject_token_grant
+	SubjectTokenGrantExchange  = "token-exchange"
+	SubjectTokenGrantJWTBearer = "jwt-bearer"
+)
+
+// defaultKubernetesTokenFile is where Kubernetes projects the service account token
+const defaultKubernetesTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// AuthenticateWithWorkloadIdentity exchanges a platform-issued JWT (Kubernetes
+// service account, GitHub Actions OIDC or any JWT file) for a tenant access
+// token, so workloads do not need a static client secret.
+func AuthenticateWithWorkloadIdentity(cfg *config.Config, configFile string) error {
+	subjectToken, err := readSubjectToken(cfg.SubjectTokenSource)
+	if err != nil {
+		return fmt.Errorf("error reading subject token: %s", err)
+	}
+
+	data := url.Values{}
+	switch cfg.SubjectTokenGrant {
+	case "", SubjectTokenGrantExchange:
+		// RFC 8693 token exchange
+		data.Set("grant_type", tokenExchangeGrantType)
+		data.Set("subject_token", subjectToken)
+		data.Set("subject_token_type", jwtTokenType)
+		data.Set("requested_token_type", accessTokenType)
+	case SubjectTokenGrantJWTBearer:
+		// RFC 7523 JWT bearer grant
+		data.Set("grant_type", jwtBearerGrantType)
+		data.Set("assertion", subjectToken)
+	default:
+		return fmt.Errorf("unknown subject_token_grant %q", cfg.SubjectTokenGrant)
+	}
+
+	if cfg.ClientID != "" {
+		data.Set("client_id", cfg.ClientID)
+	}
+	if scope := scopeParam(cfg, ""); scope != "" {
+		data.Set("scope", scope)
+	}
+	if cfg.Audience != "" {
+		data.Set("audience", cfg.Audience)
+	}
+
+	tokenResponse, err := requestToken(tokenURL(cfg), data)
+	if err != nil {
+		return err
+	}
+
+	return saveTokenResponse(cfg, configFile, AuthModeWorkloadIdentity, tokenResponse)
+}
+
+// readSubjectToken reads the JWT described by source:
+//
+//	kubernetes[:/path]  projected service account token
+//	github[:audience]   GitHub Actions OIDC token
+//	file:/path          any JWT file
+//	env:NAME            JWT in an environment variable
+//	exec:command        JWT printed by a command
+func readSubjectToken(source string) (string, error) {
+	kind, arg := source, ""
+	if i := strings.Index(source, ":"); i >= 0 {
+		kind, arg = source[:i], source[i+1:]
+	}
+
+	var token string
+	switch kind {
+	case "kubernetes":
+		if arg == "" {
+			arg = defaultKubernetesTokenFile
+		}
+		data, err := os.ReadFile(arg)
+		if err != nil {
+			return "", err
+		}
+		token = string(data)
+	case "file", "env", "exec":
+		var err error
+		token, err = config.ResolveSecret(source)
+		if err != nil {
+			return "", err
+		}
+	case "github":
+		var err error
+		token, err = fetchGitHubOIDCToken(arg)
+		if err != nil {
+			return "", err
+		}
+	default:
+		return "", fmt.Errorf("unknown subject_token_source %q", source)
+	}
+
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return "", errors.New("subject token is empty")
+	}
+	return token, nil
+}
+
+// fetchGitHubOIDCToken requests an ID token from the GitHub Actions runtime
+func fetchGitHubOIDCToken(audience string) (string, error) {
+	requestURL := os.Getenv("ACTIONS_ID_TOKEN_REQUEST_URL")
+	requestToken := os.Getenv("ACTIONS_ID_TOKEN_REQUEST_TOKEN")
+	if requestURL == "" || requestToken == "" {
+		return "", errors.New("GitHub OIDC is not available (does the workflow have id-token: write permission?)")
+	}
+
+	if audience != "" {
+		parsed, err := url.Parse(requestURL)
+		if err != nil {
+			return "", fmt.Errorf("invalid ACTIONS_ID_TOKEN_REQUEST_URL: %s", err)
+		}
+		query := parsed.Query()
+		query.Set("audience", audience)
+		parsed.RawQuery = query.Encode()
+		requestURL = parsed.String()
+	}
+
+	req, err := http.NewRequest("GET", requestURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+requestToken)
+	req.Header.Set("Accept", "application/json")
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("GitHub OIDC token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("GitHub OIDC token request failed with status: %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	var tokenResponse struct {
+		Value string `json:"value"`
+	}
+	if err := json.Unmarshal(body, &tokenResponse); err != nil {
+		return "", fmt.Errorf("error parsing GitHub OIDC response: %s", err)
+	}
+
+	return tokenResponse.Value, nil
+}
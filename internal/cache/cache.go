@@ -0,0 +1,260 @@
+package cache
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/infamousjoeg/summon-wpm/internal/keyring"
+)
+
+// Keyring entry holding the cache key
+const (
+	keyringService = "summon-wpm"
+	keyringAccount = "credential-cache-key"
+)
+
+// keySize is the AES-256 key length
+const keySize = 32
+
+// Cache keeps credential values on disk, each encrypted with AES-256-GCM.
+// Entries are named by an HMAC of the scope and the credential ID under the
+// cache key, so the files reveal neither, entries of one scope cannot be read
+// in another, and caches with different keys, such as an agent's and the
+// CLI's, never overwrite each other's entries.
+type Cache struct {
+	dir   string
+	scope string
+	key   []byte
+	aead  cipher.AEAD
+}
+
+// Entry is a cached credential value
+type Entry struct {
+	Value     string
+	FetchedAt time.Time
+}
+
+// StaleError reports a credential served from the cache after it could not
+// be fetched. The cached value is returned along with it.
+type StaleError struct {
+	ID        string    `json:"id"`
+	FetchedAt time.Time `json:"fetched_at"`
+	// Reason is why the credential could not be fetched
+	Reason string `json:"reason"`
+}
+
+func (e *StaleError) Error() string {
+	return fmt.Sprintf("using cached credential for %s fetched %s ago: %s",
+		e.ID, time.Since(e.FetchedAt).Round(time.Second), e.Reason)
+}
+
+// entryFile is the on-disk form of an entry. The file name and FetchedAt are
+// authenticated along with the ciphertext.
+type entryFile struct {
+	FetchedAt  int64  `json:"fetched_at"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// Dir returns where cache entries are kept: $SUMMON_WPM_CACHE_DIR, or else
+// the user's cache directory
+func Dir() string {
+	if dir := os.Getenv("SUMMON_WPM_CACHE_DIR"); dir != "" {
+		return dir
+	}
+	if dir, err := os.UserCacheDir(); err == nil {
+		return filepath.Join(dir, "summon-wpm", "credentials")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("summon-wpm-%d", os.Getuid()), "credentials")
+}
+
+// New returns a cache in dir for the given scope, normally the tenant and
+// identity, encrypting entries with key
+func New(dir, scope string, key []byte) (*Cache, error) {
+	if len(key) != keySize {
+		return nil, fmt.Errorf("cache key must be %d bytes", keySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Cache{dir: dir, scope: scope, key: key, aead: aead}, nil
+}
+
+// NewKey returns a random cache key, such as an agent keeps in memory
+func NewKey() []byte {
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		panic(fmt.Sprintf("error generating cache key: %s", err))
+	}
+	return key
+}
+
+// KeyringKey returns the cache key stored in the system keyring, creating
+// one on first use
+func KeyringKey() ([]byte, error) {
+	encoded, err := keyring.Get(keyringService, keyringAccount)
+	if err == nil {
+		key, err := hex.DecodeString(encoded)
+		if err == nil && len(key) == keySize {
+			return key, nil
+		}
+		// A damaged key only makes existing entries unreadable; replace it
+	} else if !errors.Is(err, keyring.ErrNotFound) {
+		return nil, err
+	}
+
+	key := NewKey()
+	if err := keyring.Set(keyringService, keyringAccount, hex.EncodeToString(key)); err != nil {
+		return nil, fmt.Errorf("error storing cache key in keyring: %s", err)
+	}
+	return key, nil
+}
+
+// Get returns the cached entry for id, or nil when there is none. Entries
+// that cannot be decrypted, such as those written with another key, count
+// as missing.
+func (c *Cache) Get(id string) (*Entry, error) {
+	name := c.name(id)
+	data, err := os.ReadFile(filepath.Join(c.dir, name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var file entryFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, nil
+	}
+	if len(file.Nonce) != c.aead.NonceSize() {
+		return nil, nil
+	}
+	value, err := c.aead.Open(nil, file.Nonce, file.Ciphertext, additionalData(name, file.FetchedAt))
+	if err != nil {
+		return nil, nil
+	}
+	return &Entry{Value: string(value), FetchedAt: time.Unix(file.FetchedAt, 0)}, nil
+}
+
+// Put stores value for id, fetched now
+func (c *Cache) Put(id, value string) error {
+	if err := os.MkdirAll(c.dir, 0700); err != nil {
+		return fmt.Errorf("error creating cache directory: %s", err)
+	}
+
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	name := c.name(id)
+	fetchedAt := time.Now().Unix()
+	data, err := json.Marshal(entryFile{
+		FetchedAt:  fetchedAt,
+		Nonce:      nonce,
+		Ciphertext: c.aead.Seal(nil, nonce, []byte(value), additionalData(name, fetchedAt)),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file and rename it so readers never see half an entry
+	tmp, err := os.CreateTemp(c.dir, name+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(c.dir, name)); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// Delete removes the entry for id
+func (c *Cache) Delete(id string) error {
+	err := os.Remove(filepath.Join(c.dir, c.name(id)))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// name is the file name of the entry for id
+func (c *Cache) name(id string) string {
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write([]byte(c.scope + "\x00" + id))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// additionalData binds an entry's ciphertext to its file name and fetch
+// time, so neither can be changed without the entry failing to decrypt
+func additionalData(name string, fetchedAt int64) []byte {
+	return []byte(name + "\x00" + strconv.FormatInt(fetchedAt, 10))
+}
+
+// entryPattern matches the names of entry files and of their temporary files
+var entryPattern = regexp.MustCompile(`^[0-9a-f]{64}(\..+\.tmp)?$`)
+
+// Purge removes every cache entry and the cache key from the keyring, so
+// any entry left behind can no longer be decrypted
+func Purge(dir string) error {
+	if err := removeEntries(dir); err != nil {
+		return err
+	}
+	if err := keyring.Delete(keyringService, keyringAccount); err != nil && !errors.Is(err, keyring.ErrUnsupported) {
+		return fmt.Errorf("error removing cache key from keyring: %s", err)
+	}
+	return nil
+}
+
+// removeEntries deletes the entry files in dir. Other files are left alone,
+// and dir itself is only removed once it is empty.
+func removeEntries(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error reading cache: %s", err)
+	}
+	remaining := 0
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || !entryPattern.MatchString(entry.Name()) {
+			remaining++
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error removing cache entry: %s", err)
+		}
+	}
+	if err == nil && remaining == 0 {
+		if err := os.Remove(dir); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error removing cache directory: %s", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	dir, err := os.MkdirTemp("", "summon-wpm-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	key := NewKey()
+	c, err := New(dir, "https://tenant.example\x00alice", key)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	if entry, err := c.Get("app-1"); err != nil || entry != nil {
+		t.Fatalf("Get on empty cache = %+v, %v", entry, err)
+	}
+
+	if err := c.Put("app-1", "secret-1"); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	entry, err := c.Get("app-1")
+	if err != nil || entry == nil || entry.Value != "secret-1" {
+		t.Fatalf("Get = %+v, %v", entry, err)
+	}
+	if time.Since(entry.FetchedAt) > time.Minute {
+		t.Errorf("FetchedAt = %s", entry.FetchedAt)
+	}
+
+	// The value is not stored in the clear, and the file name does not reveal the app
+	files, err := os.ReadDir(dir)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Expected one cache file, got %v, %v", files, err)
+	}
+	info, _ := files[0].Info()
+	if info.Mode().Perm()&0077 != 0 {
+		t.Errorf("Cache file mode = %o", info.Mode().Perm())
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, files[0].Name()))
+	for _, clear := range []string{"secret-1", "app-1"} {
+		if strings.Contains(string(data)+files[0].Name(), clear) {
+			t.Errorf("Cache file reveals %q", clear)
+		}
+	}
+
+	// Another identity, or another key, does not see the entry
+	other, _ := New(dir, "https://tenant.example\x00bob", key)
+	if entry, err := other.Get("app-1"); err != nil || entry != nil {
+		t.Errorf("Other scope Get = %+v, %v", entry, err)
+	}
+	rekeyed, _ := New(dir, "https://tenant.example\x00alice", NewKey())
+	if entry, err := rekeyed.Get("app-1"); err != nil || entry != nil {
+		t.Errorf("Other key Get = %+v, %v", entry, err)
+	}
+
+	// nor does it replace it, as an agent's cache shares the CLI's directory
+	if err := rekeyed.Put("app-1", "agent-secret"); err != nil {
+		t.Fatalf("Put with other key failed: %v", err)
+	}
+	if entry, err := c.Get("app-1"); err != nil || entry == nil || entry.Value != "secret-1" {
+		t.Errorf("Get after other key Put = %+v, %v", entry, err)
+	}
+	if err := rekeyed.Delete("app-1"); err != nil {
+		t.Fatalf("Delete with other key failed: %v", err)
+	}
+
+	// The fetch time cannot be changed to make an entry look fresh
+	name := filepath.Join(dir, files[0].Name())
+	tampered := strings.Replace(string(data), `"fetched_at":`, `"fetched_at":1`, 1)
+	if err := os.WriteFile(name, []byte(tampered), 0600); err != nil {
+		t.Fatalf("Failed to tamper with entry: %v", err)
+	}
+	if entry, err := c.Get("app-1"); err != nil || entry != nil {
+		t.Errorf("Tampered entry Get = %+v, %v", entry, err)
+	}
+	if err := os.WriteFile(name, data, 0600); err != nil {
+		t.Fatalf("Failed to restore entry: %v", err)
+	}
+
+	if err := c.Delete("app-1"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if entry, _ := c.Get("app-1"); entry != nil {
+		t.Errorf("Entry still present after Delete: %+v", entry)
+	}
+
+	if _, err := New(dir, "scope", []byte("short")); err == nil {
+		t.Error("Expected an error for a short key")
+	}
+}
+
+func TestRemoveEntries(t *testing.T) {
+	dir := t.TempDir()
+
+	c, err := New(dir, "scope", NewKey())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if err := c.Put("app-1", "secret-1"); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	leftover := strings.Repeat("ab", 32) + ".123.tmp"
+	if err := os.WriteFile(filepath.Join(dir, leftover), nil, 0600); err != nil {
+		t.Fatalf("Failed to write temp file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("keep"), 0600); err != nil {
+		t.Fatalf("Failed to write other file: %v", err)
+	}
+
+	// Files that are not cache entries survive, and so does the directory
+	if err := removeEntries(dir); err != nil {
+		t.Fatalf("removeEntries failed: %v", err)
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil || len(files) != 1 || files[0].Name() != "notes.txt" {
+		t.Fatalf("Expected only notes.txt to remain, got %v, %v", files, err)
+	}
+
+	// Once only entries are left, the directory goes too
+	os.Remove(filepath.Join(dir, "notes.txt"))
+	if err := c.Put("app-1", "secret-1"); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := removeEntries(dir); err != nil {
+		t.Fatalf("removeEntries failed: %v", err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("Expected the empty cache directory to be removed, got %v", err)
+	}
+}
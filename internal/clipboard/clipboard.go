@@ -0,0 +1,68 @@
+package clipboard
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// copyTimeout bounds how long a clipboard tool may take
+const copyTimeout = 5 * time.Second
+
+// stderrWaitDelay bounds the wait for stderr to close once the tool has
+// exited. xclip and xsel leave a child running to serve the selection, and it
+// keeps the inherited stderr open.
+const stderrWaitDelay = 500 * time.Millisecond
+
+// commands are the clipboard tools tried in order on each platform
+var commands = map[string][][]string{
+	"darwin":  {{"pbcopy"}},
+	"windows": {{"clip.exe"}},
+	"linux": {
+		{"wl-copy"},
+		{"xclip", "-selection", "clipboard"},
+		{"xsel", "--clipboard", "--input"},
+	},
+}
+
+// Copy puts text on the system clipboard using the platform's clipboard tool
+func Copy(text string) error {
+	candidates := commands[runtime.GOOS]
+	if runtime.GOOS != "darwin" && runtime.GOOS != "windows" {
+		candidates = commands["linux"]
+	}
+
+	for _, command := range candidates {
+		// wl-copy only works inside a Wayland session
+		if command[0] == "wl-copy" && os.Getenv("WAYLAND_DISPLAY") == "" {
+			continue
+		}
+
+		path, err := exec.LookPath(command[0])
+		if err != nil {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), copyTimeout)
+		defer cancel()
+
+		// Stdout is left unset so the tool's background child holds no pipe
+		var stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, path, command[1:]...)
+		cmd.Stdin = strings.NewReader(text)
+		cmd.Stderr = &stderr
+		cmd.WaitDelay = stderrWaitDelay
+		if err := cmd.Run(); err != nil && !errors.Is(err, exec.ErrWaitDelay) {
+			return fmt.Errorf("%s failed: %s %s", command[0], err, strings.TrimSpace(stderr.String()))
+		}
+		return nil
+	}
+
+	return errors.New("no clipboard tool found (install xclip, xsel or wl-clipboard)")
+}
@@ -0,0 +1,54 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// CacheConfig turns on the local credential cache. TTLs are Go durations
+// such as "10m"; an app without a TTL, or with "0", is not cached.
+type CacheConfig struct {
+	// TTL applies to apps not listed in Apps
+	TTL string `json:"ttl,omitempty"`
+	// Apps sets the TTL of individual app IDs
+	Apps map[string]string `json:"apps,omitempty"`
+	// StaleIfError sets, per app ID, the maximum age of a cached credential
+	// that may be used when the tenant cannot be reached
+	StaleIfError map[string]string `json:"stale_if_error,omitempty"`
+}
+
+// AppTTL returns how long credentials of appID may be served from the cache
+func (c *CacheConfig) AppTTL(appID string) (time.Duration, error) {
+	if c == nil {
+		return 0, nil
+	}
+
+	ttl, ok := c.Apps[appID]
+	if !ok {
+		ttl = c.TTL
+	}
+	return parseCacheDuration(ttl, "cache TTL", appID)
+}
+
+// AppMaxStale returns the maximum age of a cached credential of appID that
+// may be used when the tenant cannot be reached; 0 when the app has no
+// stale-if-error policy
+func (c *CacheConfig) AppMaxStale(appID string) (time.Duration, error) {
+	if c == nil {
+		return 0, nil
+	}
+	return parseCacheDuration(c.StaleIfError[appID], "stale_if_error age", appID)
+}
+
+// parseCacheDuration parses a cache setting, treating an empty one as 0
+func parseCacheDuration(value, setting, appID string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration < 0 {
+		return 0, fmt.Errorf("invalid %s %q for %s", setting, value, appID)
+	}
+	return duration, nil
+}
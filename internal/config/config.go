@@ -8,6 +8,8 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+
+	"github.com/infamousjoeg/summon-wpm/internal/discovery"
 )
 
 const defaultConfigFileName = "cyberark-wpm.json"
@@ -15,11 +17,67 @@ const defaultConfigFileName = "cyberark-wpm.json"
 // Config stores the configuration for the provider
 type Config struct {
 	TenantURL    string `json:"tenant_url"`
+	TenantID     string `json:"tenant_id,omitempty"`
 	Username     string `json:"username"`
 	ClientID     string `json:"client_id,omitempty"`
 	ClientSecret string `json:"client_secret,omitempty"`
-	AuthToken    string `json:"auth_token,omitempty"`
-	TokenExpiry  int64  `json:"token_expiry,omitempty"`
+
+	// Save the pod that StartAuthentication redirects to as the tenant URL
+	PersistPodRedirect bool `json:"persist_pod_redirect,omitempty"`
+
+	// private_key_jwt client authentication instead of a client secret
+	ClientAssertionKeyFile string `json:"client_assertion_key_file,omitempty"`
+	ClientAssertionKeyID   string `json:"client_assertion_key_id,omitempty"`
+
+	AuthToken   string `json:"auth_token,omitempty"`
+	TokenExpiry int64  `json:"token_expiry,omitempty"`
+	TokenSource string `json:"token_source,omitempty"`
+
+	// When the token was last renewed with the refresh token. The token
+	// source keeps naming the login that started the session.
+	TokenRefreshedAt int64 `json:"token_refreshed_at,omitempty"`
+
+	// Tenant OAuth application. When set, tokens come from
+	// /OAuth2/Token/<app id> instead of the platform token endpoint.
+	OAuthAppID       string   `json:"oauth_app_id,omitempty"`
+	OAuthClientID    string   `json:"oauth_client_id,omitempty"`
+	Scopes           []string `json:"scopes,omitempty"`
+	Audience         string   `json:"audience,omitempty"`
+	ClientAuthMethod string   `json:"client_auth_method,omitempty"`
+	RefreshToken     string   `json:"refresh_token,omitempty"`
+
+	// Workload identity federation: a platform JWT exchanged for a token
+	SubjectTokenSource string `json:"subject_token_source,omitempty"`
+	SubjectTokenGrant  string `json:"subject_token_grant,omitempty"`
+
+	// Interactive login: mechanisms to pick automatically, in order of
+	// preference, when the tenant offers them
+	PreferredMechanisms []string `json:"preferred_mechanisms,omitempty"`
+
+	// X.509 client certificate authentication over mutual TLS
+	ClientCertFile string `json:"client_cert_file,omitempty"`
+	ClientKeyFile  string `json:"client_key_file,omitempty"`
+	CACertFile     string `json:"ca_cert_file,omitempty"`
+	CertAuthURL    string `json:"cert_auth_url,omitempty"`
+
+	// Persistent cookies from interactive login, such as the trusted-device
+	// cookie that lets later logins skip MFA
+	DeviceCookies []DeviceCookie `json:"device_cookies,omitempty"`
+
+	// Local credential cache, off unless configured
+	Cache *CacheConfig `json:"cache,omitempty"`
+}
+
+// DeviceCookie is a cookie kept between interactive logins
+type DeviceCookie struct {
+	Name     string `json:"name"`
+	Value    string `json:"value"`
+	Domain   string `json:"domain"`
+	Path     string `json:"path,omitempty"`
+	Expires  int64  `json:"expires"`
+	HostOnly bool   `json:"host_only,omitempty"`
+	Secure   bool   `json:"secure,omitempty"`
+	HttpOnly bool   `json:"http_only,omitempty"`
 }
 
 // GetConfigFilePathFunc defines the function signature for getting config file path
@@ -27,23 +85,27 @@ type GetConfigFilePathFunc func() string
 
 // getConfigFilePathImpl is the actual implementation
 func getConfigFilePathImpl() string {
+	configDir := os.Getenv("SUMMON_WPM_CONFIG_DIR")
+	if configDir == "" {
+		configDir = defaultConfigDir()
+	}
+
+	return filepath.Join(configDir, ProfileFileName(ActiveProfile()))
+}
+
+// defaultConfigDir returns the summon-wpm directory in the user's config
+// directory, which summon-wpm creates and owns
+func defaultConfigDir() string {
 	// Determine config directory based on OS
-	var configDir string
-
-	if os.Getenv("SUMMON_WPM_CONFIG_DIR") != "" {
-		configDir = os.Getenv("SUMMON_WPM_CONFIG_DIR")
-	} else if runtime.GOOS == "windows" {
-		configDir = filepath.Join(os.Getenv("APPDATA"), "summon-wpm")
-	} else {
-		// Unix-like systems
-		if os.Getenv("XDG_CONFIG_HOME") != "" {
-			configDir = filepath.Join(os.Getenv("XDG_CONFIG_HOME"), "summon-wpm")
-		} else {
-			configDir = filepath.Join(os.Getenv("HOME"), ".config", "summon-wpm")
-		}
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("APPDATA"), "summon-wpm")
 	}
 
-	return filepath.Join(configDir, defaultConfigFileName)
+	// Unix-like systems
+	if os.Getenv("XDG_CONFIG_HOME") != "" {
+		return filepath.Join(os.Getenv("XDG_CONFIG_HOME"), "summon-wpm")
+	}
+	return filepath.Join(os.Getenv("HOME"), ".config", "summon-wpm")
 }
 
 // GetConfigFilePath is the function variable that can be replaced in tests
@@ -67,16 +129,29 @@ func RunConfigWizard(configFile string) {
 	if err == nil {
 		config = existingConfig
 		fmt.Println("Loaded existing configuration. Press Enter to keep current values.")
+	} else if permErr, ok := err.(*PermissionError); ok {
+		fmt.Fprintln(os.Stderr, permErr)
+		os.Exit(1)
 	}
 
 	reader := bufio.NewReader(os.Stdin)
 
-	// Get tenant URL
-	fmt.Printf("Tenant URL [%s]: ", config.TenantURL)
+	// Get tenant URL or ID
+	currentTenant := config.TenantURL
+	if currentTenant == "" {
+		currentTenant = config.TenantID
+	}
+	fmt.Printf("Tenant URL or ID, blank to discover from username [%s]: ", currentTenant)
 	tenantURL, _ := reader.ReadString('\n')
 	tenantURL = strings.TrimSpace(tenantURL)
 	if tenantURL != "" {
-		config.TenantURL = tenantURL
+		if discovery.IsTenantURL(tenantURL) {
+			config.TenantURL = tenantURL
+			config.TenantID = ""
+		} else {
+			config.TenantURL = ""
+			config.TenantID = tenantURL
+		}
 	}
 
 	// Get username
@@ -87,6 +162,28 @@ func RunConfigWizard(configFile string) {
 		config.Username = username
 	}
 
+	// Get preferred MFA mechanisms
+	fmt.Printf("Preferred MFA mechanisms, comma separated (e.g. OATH,SMS) [%s]: ", strings.Join(config.PreferredMechanisms, ","))
+	preferred, _ := reader.ReadString('\n')
+	preferred = strings.TrimSpace(preferred)
+	if preferred != "" {
+		config.PreferredMechanisms = nil
+		for _, mechanism := range strings.Split(preferred, ",") {
+			if mechanism = strings.TrimSpace(mechanism); mechanism != "" {
+				config.PreferredMechanisms = append(config.PreferredMechanisms, mechanism)
+			}
+		}
+	}
+
+	// Resolve the tenant URL now so the user can confirm it
+	if config.TenantURL == "" {
+		if err := ResolveTenant(config, ""); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: could not discover tenant URL: %s\n", err)
+		} else {
+			fmt.Println("Discovered tenant URL:", config.TenantURL)
+		}
+	}
+
 	// Ask if they want to use service account
 	fmt.Print("Do you want to configure a service account (client credentials)? (y/n): ")
 	useService, _ := reader.ReadString('\n')
@@ -100,7 +197,12 @@ func RunConfigWizard(configFile string) {
 			config.ClientID = clientID
 		}
 
-		fmt.Printf("Client Secret [%s]: ", maskString(config.ClientSecret))
+		// Secret references are not sensitive, so show them as-is
+		currentSecret := maskString(config.ClientSecret)
+		if IsSecretReference(config.ClientSecret) {
+			currentSecret = config.ClientSecret
+		}
+		fmt.Printf("Client Secret, or file:/env:/exec: reference [%s]: ", currentSecret)
 		clientSecret, _ := reader.ReadString('\n')
 		clientSecret = strings.TrimSpace(clientSecret)
 		if clientSecret != "" {
@@ -126,8 +228,39 @@ func maskString(s string) string {
 	return "********"
 }
 
+// ResolveTenant fills in TenantURL from the tenant ID, a bare tenant ID stored
+// in tenant_url, or the username's login suffix. The resolved URL is cached
+// in configFile unless configFile is empty.
+func ResolveTenant(config *Config, configFile string) error {
+	if config.TenantURL != "" && discovery.IsTenantURL(config.TenantURL) {
+		return nil
+	}
+
+	if config.TenantURL != "" {
+		config.TenantID = config.TenantURL
+		config.TenantURL = ""
+	}
+
+	tenantURL, err := discovery.ResolveTenantURL(config.TenantID, config.Username)
+	if err != nil {
+		return err
+	}
+	config.TenantURL = tenantURL
+
+	if configFile == "" {
+		return nil
+	}
+	return SaveConfig(config, configFile)
+}
+
 // LoadConfig loads the configuration from the config file
 func LoadConfig(configFile string) (*Config, error) {
+	// The file holds client secrets and tokens, so refuse to read it if
+	// anyone else could have read or replaced it
+	if err := CheckPermissions(configFile); err != nil {
+		return nil, err
+	}
+
 	data, err := os.ReadFile(configFile)
 	if err != nil {
 		return nil, err
@@ -1,9 +1,16 @@
 package config
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/infamousjoeg/summon-wpm/internal/discovery"
 )
 
 func TestSaveAndLoadConfig(t *testing.T) {
@@ -92,3 +99,275 @@ func TestMaskString(t *testing.T) {
 		}
 	}
 }
+
+func TestLoadConfigInsecurePermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("permission bits are not enforced on Windows")
+	}
+
+	tmpDir, err := os.MkdirTemp("", "summon-wpm-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	configFile := filepath.Join(tmpDir, defaultConfigFileName)
+	if err := SaveConfig(&Config{TenantURL: "https://example.cyberark.cloud"}, configFile); err != nil {
+		t.Fatalf("Failed to save config: %v", err)
+	}
+
+	// World-readable file should be refused
+	if err := os.Chmod(configFile, 0644); err != nil {
+		t.Fatalf("Failed to chmod config: %v", err)
+	}
+	_, err = LoadConfig(configFile)
+	if _, ok := err.(*PermissionError); !ok {
+		t.Fatalf("Expected PermissionError for 0644 file, got %v", err)
+	}
+
+	// A directory others can read is fine, one they can write to is not
+	if err := os.Chmod(configFile, 0600); err != nil {
+		t.Fatalf("Failed to chmod config: %v", err)
+	}
+	if err := os.Chmod(tmpDir, 0755); err != nil {
+		t.Fatalf("Failed to chmod config dir: %v", err)
+	}
+	if _, err := LoadConfig(configFile); err != nil {
+		t.Fatalf("Expected 0755 directory to be accepted, got %v", err)
+	}
+	if err := os.Chmod(tmpDir, 0775); err != nil {
+		t.Fatalf("Failed to chmod config dir: %v", err)
+	}
+	_, err = LoadConfig(configFile)
+	if _, ok := err.(*PermissionError); !ok {
+		t.Fatalf("Expected PermissionError for 0775 directory, got %v", err)
+	}
+
+	// FixPermissions does not change a directory summon-wpm did not create
+	if err := FixPermissions(configFile); err == nil {
+		t.Fatal("Expected FixPermissions to report a group-writable directory")
+	}
+	if err := os.Chmod(tmpDir, 0755); err != nil {
+		t.Fatalf("Failed to chmod config dir: %v", err)
+	}
+
+	// FixPermissions should make the config usable again
+	if err := os.Chmod(configFile, 0666); err != nil {
+		t.Fatalf("Failed to chmod config: %v", err)
+	}
+	if err := FixPermissions(configFile); err != nil {
+		t.Fatalf("FixPermissions failed: %v", err)
+	}
+	if _, err := LoadConfig(configFile); err != nil {
+		t.Fatalf("LoadConfig after FixPermissions failed: %v", err)
+	}
+
+	dirInfo, err := os.Stat(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to stat config dir: %v", err)
+	}
+	if dirInfo.Mode().Perm() != 0755 {
+		t.Errorf("Config dir mode = %04o, want it left at 0755", dirInfo.Mode().Perm())
+	}
+
+	info, err := os.Stat(configFile)
+	if err != nil {
+		t.Fatalf("Failed to stat config: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Config file mode = %04o, want 0600", info.Mode().Perm())
+	}
+}
+
+func TestResolveTenantCachesURL(t *testing.T) {
+	// The tenant's own pod answers without a redirect
+	pod := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"success": true, "Result": {}}`))
+	}))
+	defer pod.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"success": true, "Result": {"PodFqdn": "` + pod.URL + `"}}`))
+	}))
+	defer server.Close()
+
+	origDiscoveryURL := discovery.DiscoveryURL
+	defer func() { discovery.DiscoveryURL = origDiscoveryURL }()
+	discovery.DiscoveryURL = server.URL
+
+	tmpDir, err := os.MkdirTemp("", "summon-wpm-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	configFile := filepath.Join(tmpDir, defaultConfigFileName)
+	cfg := &Config{Username: "user@example.com"}
+
+	if err := ResolveTenant(cfg, configFile); err != nil {
+		t.Fatalf("ResolveTenant failed: %v", err)
+	}
+	if cfg.TenantURL != pod.URL {
+		t.Errorf("TenantURL = %s, want %s", cfg.TenantURL, pod.URL)
+	}
+
+	loadedCfg, err := LoadConfig(configFile)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if loadedCfg.TenantURL != cfg.TenantURL {
+		t.Errorf("Cached TenantURL = %s, want %s", loadedCfg.TenantURL, cfg.TenantURL)
+	}
+}
+
+func TestResolveSecret(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "summon-wpm-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	secretFile := filepath.Join(tmpDir, "secret")
+	if err := os.WriteFile(secretFile, []byte("from-file\n"), 0600); err != nil {
+		t.Fatalf("Failed to write secret file: %v", err)
+	}
+	t.Setenv("WPM_TEST_SECRET", "from-env")
+
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{"plain-secret", "plain-secret"},
+		{"file:" + secretFile, "from-file"},
+		{"env:WPM_TEST_SECRET", "from-env"},
+	}
+	if runtime.GOOS != "windows" {
+		tests = append(tests, struct {
+			value    string
+			expected string
+		}{"exec:echo from-exec", "from-exec"})
+	}
+
+	for _, tt := range tests {
+		result, err := ResolveSecret(tt.value)
+		if err != nil {
+			t.Errorf("ResolveSecret(%q) failed: %v", tt.value, err)
+			continue
+		}
+		if result != tt.expected {
+			t.Errorf("ResolveSecret(%q) = %q, want %q", tt.value, result, tt.expected)
+		}
+	}
+
+	if _, err := ResolveSecret("env:WPM_TEST_SECRET_UNSET"); err == nil {
+		t.Error("Expected error for unset environment variable")
+	}
+
+	if runtime.GOOS != "windows" {
+		origTimeout := SecretCommandTimeout
+		defer func() { SecretCommandTimeout = origTimeout }()
+		SecretCommandTimeout = 100 * time.Millisecond
+
+		_, err := ResolveSecret("exec:sleep 5")
+		if err == nil || !strings.Contains(err.Error(), "timed out") {
+			t.Errorf("Expected timeout error, got %v", err)
+		}
+
+		// A child holding stdout open is killed with the command
+		script := filepath.Join(t.TempDir(), "helper.sh")
+		if err := os.WriteFile(script, []byte("sleep 5 &\nsleep 5\n"), 0700); err != nil {
+			t.Fatalf("Failed to write helper: %v", err)
+		}
+		start := time.Now()
+		_, err = ResolveSecret("exec:sh " + script)
+		if err == nil || !strings.Contains(err.Error(), "timed out") {
+			t.Errorf("Expected timeout error, got %v", err)
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("Expected the process group to be killed, waited %s", elapsed)
+		}
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "summon-wpm-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	origEnv := os.Getenv("SUMMON_WPM_CONFIG_DIR")
+	defer os.Setenv("SUMMON_WPM_CONFIG_DIR", origEnv)
+	os.Setenv("SUMMON_WPM_CONFIG_DIR", tmpDir)
+
+	origProfile := Profile
+	defer func() { Profile = origProfile }()
+
+	t.Setenv(ProfileEnvVar, "staging")
+	if path := GetConfigFilePath(); path != filepath.Join(tmpDir, "cyberark-wpm.staging.json") {
+		t.Errorf("Profile from environment: got %s", path)
+	}
+
+	Profile = "prod"
+	if path := GetConfigFilePath(); path != filepath.Join(tmpDir, "cyberark-wpm.prod.json") {
+		t.Errorf("Profile from flag: got %s", path)
+	}
+
+	for _, name := range []string{"cyberark-wpm.json", "cyberark-wpm.prod.json", "other.json"} {
+		if err := SaveConfig(&Config{}, filepath.Join(tmpDir, name)); err != nil {
+			t.Fatalf("Failed to save config: %v", err)
+		}
+	}
+
+	profiles, err := ProfileFiles()
+	if err != nil {
+		t.Fatalf("ProfileFiles failed: %v", err)
+	}
+	if names := ProfileNames(profiles); strings.Join(names, ",") != "default,prod" {
+		t.Errorf("ProfileNames = %v, want [default prod]", names)
+	}
+
+	for _, invalid := range []string{"", "..", "a/b"} {
+		if ValidateProfile(invalid) == nil {
+			t.Errorf("ValidateProfile(%q) succeeded", invalid)
+		}
+	}
+}
+
+func TestCacheAppTTL(t *testing.T) {
+	cache := &CacheConfig{
+		TTL:  "10m",
+		Apps: map[string]string{"long": "2h", "never": "0", "broken": "soon"},
+	}
+
+	tests := []struct {
+		appID    string
+		expected time.Duration
+	}{
+		{"other", 10 * time.Minute},
+		{"long", 2 * time.Hour},
+		{"never", 0},
+	}
+	for _, tt := range tests {
+		ttl, err := cache.AppTTL(tt.appID)
+		if err != nil || ttl != tt.expected {
+			t.Errorf("AppTTL(%s) = %s, %v; want %s", tt.appID, ttl, err, tt.expected)
+		}
+	}
+
+	if _, err := cache.AppTTL("broken"); err == nil {
+		t.Error("Expected an error for an invalid TTL")
+	}
+	cache.StaleIfError = map[string]string{"long": "24h"}
+	if maxStale, err := cache.AppMaxStale("long"); err != nil || maxStale != 24*time.Hour {
+		t.Errorf("AppMaxStale(long) = %s, %v", maxStale, err)
+	}
+	if maxStale, err := cache.AppMaxStale("other"); err != nil || maxStale != 0 {
+		t.Errorf("AppMaxStale(other) = %s, %v", maxStale, err)
+	}
+	if ttl, err := (*CacheConfig)(nil).AppTTL("any"); err != nil || ttl != 0 {
+		t.Errorf("AppTTL without a cache = %s, %v", ttl, err)
+	}
+}
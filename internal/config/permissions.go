@@ -0,0 +1,28 @@
+package config
+
+import "fmt"
+
+// PermissionError describes a config file or directory whose mode or
+// ownership would let other users read the stored secrets
+type PermissionError struct {
+	Path   string
+	Reason string
+}
+
+func (e *PermissionError) Error() string {
+	return fmt.Sprintf("refusing to use %s: %s (run with --fix-permissions to repair)", e.Path, e.Reason)
+}
+
+// CheckPermissions verifies that the config file is only accessible by the
+// current user and that no one else can write to its directory, in the same
+// way OpenSSH checks private keys
+func CheckPermissions(configFile string) error {
+	return checkPermissions(configFile)
+}
+
+// FixPermissions restricts the config file to 0600, and its directory to 0700
+// when summon-wpm created it. Ownership by another user, or a directory of
+// the user's choosing that others can write to, is reported as an error.
+func FixPermissions(configFile string) error {
+	return fixPermissions(configFile)
+}
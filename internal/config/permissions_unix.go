@@ -0,0 +1,106 @@
+//go:build !windows
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// Mode bits refused on the config directory and file. As with OpenSSH, a
+// directory others can read is fine as long as they cannot replace the file.
+const (
+	dirForbiddenBits  os.FileMode = 0022
+	fileForbiddenBits os.FileMode = 0077
+)
+
+// checkPermissions rejects group/world access to the file, a directory
+// others can write to, and foreign ownership
+func checkPermissions(configFile string) error {
+	targets := []struct {
+		path      string
+		forbidden os.FileMode
+	}{
+		{filepath.Dir(configFile), dirForbiddenBits},
+		{configFile, fileForbiddenBits},
+	}
+
+	for _, target := range targets {
+		info, err := os.Stat(target.path)
+		if err != nil {
+			return err
+		}
+
+		if err := checkOwner(target.path, info); err != nil {
+			return err
+		}
+
+		if perm := info.Mode().Perm(); perm&target.forbidden != 0 {
+			return &PermissionError{
+				Path:   target.path,
+				Reason: fmt.Sprintf("permissions %04o are too open", perm),
+			}
+		}
+	}
+
+	return nil
+}
+
+// fixPermissions tightens the file mode, and the directory mode when the
+// directory is summon-wpm's own. A directory the user chose, such as $HOME,
+// is never changed; if others can write to it that is reported instead.
+func fixPermissions(configFile string) error {
+	configDir := filepath.Dir(configFile)
+
+	info, err := os.Stat(configDir)
+	if err != nil {
+		return err
+	}
+	if err := checkOwner(configDir, info); err != nil {
+		return err
+	}
+	if filepath.Clean(configDir) == filepath.Clean(defaultConfigDir()) {
+		if info.Mode().Perm() != 0700 {
+			if err := os.Chmod(configDir, 0700); err != nil {
+				return err
+			}
+		}
+	} else if perm := info.Mode().Perm(); perm&dirForbiddenBits != 0 {
+		return &PermissionError{
+			Path:   configDir,
+			Reason: fmt.Sprintf("permissions %04o let others write to it; summon-wpm does not change directories it did not create", perm),
+		}
+	}
+
+	info, err = os.Stat(configFile)
+	if err != nil {
+		return err
+	}
+	if err := checkOwner(configFile, info); err != nil {
+		return err
+	}
+	if info.Mode().Perm() != 0600 {
+		return os.Chmod(configFile, 0600)
+	}
+
+	return nil
+}
+
+// checkOwner accepts paths owned by the current user or by root
+func checkOwner(path string, info os.FileInfo) error {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+
+	if int(stat.Uid) != os.Getuid() && stat.Uid != 0 {
+		return &PermissionError{
+			Path:   path,
+			Reason: fmt.Sprintf("owned by uid %d instead of uid %d", stat.Uid, os.Getuid()),
+		}
+	}
+
+	return nil
+}
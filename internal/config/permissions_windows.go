@@ -0,0 +1,14 @@
+//go:build windows
+
+package config
+
+// checkPermissions is a no-op on Windows, where access is governed by the
+// ACLs inherited from %APPDATA% rather than by mode bits
+func checkPermissions(configFile string) error {
+	return nil
+}
+
+// fixPermissions is a no-op on Windows
+func fixPermissions(configFile string) error {
+	return nil
+}
@@ -0,0 +1,81 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DefaultProfile is the profile stored in cyberark-wpm.json
+const DefaultProfile = "default"
+
+// ProfileEnvVar selects the profile when --profile is not given
+const ProfileEnvVar = "SUMMON_WPM_PROFILE"
+
+// Profile is the profile chosen on the command line. When empty,
+// SUMMON_WPM_PROFILE or the default profile is used.
+var Profile string
+
+// ActiveProfile returns the name of the profile in use
+func ActiveProfile() string {
+	if Profile != "" {
+		return Profile
+	}
+	if profile := os.Getenv(ProfileEnvVar); profile != "" {
+		return profile
+	}
+	return DefaultProfile
+}
+
+// ValidateProfile rejects profile names that cannot be used in a file name
+func ValidateProfile(profile string) error {
+	if profile == "" || profile == "." || profile == ".." || strings.ContainsAny(profile, `/\`) {
+		return fmt.Errorf("invalid profile name %q", profile)
+	}
+	return nil
+}
+
+// ProfileFileName returns the config file name for a profile. Each profile
+// keeps its settings and tokens in its own file next to the default one.
+func ProfileFileName(profile string) string {
+	if profile == "" || profile == DefaultProfile {
+		return defaultConfigFileName
+	}
+	return strings.TrimSuffix(defaultConfigFileName, ".json") + "." + profile + ".json"
+}
+
+// ProfileFiles returns the config file of every profile in the config
+// directory, keyed by profile name
+func ProfileFiles() (map[string]string, error) {
+	configDir := filepath.Dir(GetConfigFilePath())
+	prefix := strings.TrimSuffix(defaultConfigFileName, ".json")
+
+	matches, err := filepath.Glob(filepath.Join(configDir, prefix+"*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("error listing profiles: %s", err)
+	}
+
+	profiles := map[string]string{}
+	for _, match := range matches {
+		name := strings.TrimSuffix(filepath.Base(match), ".json")
+		switch {
+		case name == prefix:
+			profiles[DefaultProfile] = match
+		case strings.HasPrefix(name, prefix+"."):
+			profiles[strings.TrimPrefix(name, prefix+".")] = match
+		}
+	}
+	return profiles, nil
+}
+
+// ProfileNames returns the names of all profiles, sorted
+func ProfileNames(profiles map[string]string) []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
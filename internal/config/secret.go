@@ -0,0 +1,104 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// SecretCommandTimeout bounds how long an exec: secret reference may run
+var SecretCommandTimeout = 30 * time.Second
+
+// secretCommandWaitDelay is how long to wait for the output of a secret
+// command to close after it was killed
+const secretCommandWaitDelay = 2 * time.Second
+
+// secretPrefixes are the supported secret reference schemes
+var secretPrefixes = []string{"file:", "env:", "exec:"}
+
+// IsSecretReference reports whether value points at a secret instead of holding it
+func IsSecretReference(value string) bool {
+	for _, prefix := range secretPrefixes {
+		if strings.HasPrefix(value, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ResolveSecret returns the secret a config value refers to:
+//
+//	file:/run/secrets/wpm   contents of the file
+//	env:WPM_SECRET          value of the environment variable
+//	exec:/usr/bin/get-secret arg...   stdout of the command
+//
+// Any other value is returned unchanged. Trailing newlines are stripped.
+func ResolveSecret(value string) (string, error) {
+	var secret string
+
+	switch {
+	case strings.HasPrefix(value, "file:"):
+		data, err := os.ReadFile(strings.TrimPrefix(value, "file:"))
+		if err != nil {
+			return "", fmt.Errorf("error reading secret file: %s", err)
+		}
+		secret = string(data)
+	case strings.HasPrefix(value, "env:"):
+		name := strings.TrimPrefix(value, "env:")
+		var ok bool
+		secret, ok = os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("secret environment variable %s is not set", name)
+		}
+	case strings.HasPrefix(value, "exec:"):
+		var err error
+		secret, err = runSecretCommand(strings.TrimPrefix(value, "exec:"))
+		if err != nil {
+			return "", err
+		}
+	default:
+		return value, nil
+	}
+
+	secret = strings.TrimRight(secret, "\r\n")
+	if secret == "" {
+		return "", errors.New("secret reference resolved to an empty value")
+	}
+	return secret, nil
+}
+
+// runSecretCommand runs a secret helper and returns its stdout
+func runSecretCommand(command string) (string, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return "", errors.New("empty exec: secret command")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), SecretCommandTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	// Children that keep stdout open must not hold up the timeout
+	cmd.WaitDelay = secretCommandWaitDelay
+	startInProcessGroup(cmd)
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return "", fmt.Errorf("secret command timed out after %s", SecretCommandTimeout)
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("secret command failed: %s: %s", err, msg)
+		}
+		return "", fmt.Errorf("secret command failed: %s", err)
+	}
+
+	return stdout.String(), nil
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+
+package config
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// startInProcessGroup runs the command in its own process group so that a
+// timeout also stops any children it started
+func startInProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
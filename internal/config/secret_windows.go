@@ -0,0 +1,9 @@
+//go:build windows
+
+package config
+
+import "os/exec"
+
+// startInProcessGroup leaves the command as is on Windows, where the
+// command is killed on timeout and WaitDelay stops waiting for its children
+func startInProcessGroup(cmd *exec.Cmd) {}
@@ -0,0 +1,153 @@
+package discovery
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const startAuthEndpoint = "/Security/StartAuthentication"
+
+// maxRedirects bounds how many PodFqdn hops are followed during discovery
+const maxRedirects = 3
+
+// DiscoveryURL is the Identity pod used to locate a tenant from a login suffix
+var DiscoveryURL = "https://pod0.idaptive.app"
+
+// TenantURLTemplate builds a tenant URL from a bare tenant ID
+var TenantURLTemplate = "https://%s.id.cyberark.cloud"
+
+// startAuthResponse holds the fields of StartAuthentication used for discovery
+type startAuthResponse struct {
+	Success bool `json:"success"`
+	Result  struct {
+		PodFqdn string `json:"PodFqdn"`
+	} `json:"Result"`
+	ErrorMsg string `json:"ErrorMsg"`
+}
+
+// IsTenantURL reports whether s is a URL or hostname rather than a bare tenant
+// ID. Tenant IDs have no dots or colons, so a host with a port counts as a
+// URL, and so does localhost.
+func IsTenantURL(s string) bool {
+	return strings.Contains(s, "://") || strings.ContainsAny(s, ".:") || strings.EqualFold(s, "localhost")
+}
+
+// PodURL turns a PodFqdn (or any hostname) into a base URL
+func PodURL(fqdn string) string {
+	fqdn = strings.TrimRight(fqdn, "/")
+	if strings.Contains(fqdn, "://") {
+		return fqdn
+	}
+	return "https://" + fqdn
+}
+
+// LoginSuffix returns the part of a username after the @, if any
+func LoginSuffix(username string) string {
+	if i := strings.LastIndex(username, "@"); i >= 0 && i < len(username)-1 {
+		return username[i+1:]
+	}
+	return ""
+}
+
+// ResolveTenantURL returns the canonical tenant URL for a tenant ID, tenant
+// hostname or, when tenant is empty, the login suffix of username
+func ResolveTenantURL(tenant, username string) (string, error) {
+	if tenant != "" && IsTenantURL(tenant) {
+		return PodURL(tenant), nil
+	}
+
+	if tenant != "" {
+		baseURL := fmt.Sprintf(TenantURLTemplate, tenant)
+		if username == "" {
+			return baseURL, nil
+		}
+		return FollowPodRedirects(baseURL, username)
+	}
+
+	if LoginSuffix(username) == "" {
+		return "", errors.New("no tenant configured and username has no login suffix to discover it from")
+	}
+
+	tenantURL, err := FollowPodRedirects(DiscoveryURL, username)
+	if err != nil {
+		return "", fmt.Errorf("tenant discovery for %s failed: %s", LoginSuffix(username), err)
+	}
+	if tenantURL == strings.TrimRight(DiscoveryURL, "/") {
+		return "", fmt.Errorf("no tenant found for login suffix %s", LoginSuffix(username))
+	}
+
+	return tenantURL, nil
+}
+
+// FollowPodRedirects starts authentication for username at baseURL and follows
+// any PodFqdn redirects, returning the URL of the pod that owns the user
+func FollowPodRedirects(baseURL, username string) (string, error) {
+	baseURL = strings.TrimRight(baseURL, "/")
+
+	for i := 0; i < maxRedirects; i++ {
+		podFqdn, err := lookupPod(baseURL, username)
+		if err != nil {
+			return "", err
+		}
+
+		if podFqdn == "" || PodURL(podFqdn) == baseURL {
+			return baseURL, nil
+		}
+
+		baseURL = PodURL(podFqdn)
+	}
+
+	return "", errors.New("too many tenant redirects")
+}
+
+// lookupPod calls StartAuthentication and returns the PodFqdn, if any
+func lookupPod(baseURL, username string) (string, error) {
+	body, err := json.Marshal(map[string]string{
+		"User":    username,
+		"Version": "1.0",
+	})
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequest("POST", baseURL+startAuthEndpoint, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.New("request failed with status: " + resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	var startAuthResponse startAuthResponse
+	if err := json.Unmarshal(data, &startAuthResponse); err != nil {
+		return "", fmt.Errorf("error parsing start auth response: %s", err)
+	}
+
+	if !startAuthResponse.Success {
+		return "", fmt.Errorf("start authentication failed: %s", startAuthResponse.ErrorMsg)
+	}
+
+	return startAuthResponse.Result.PodFqdn, nil
+}
@@ -0,0 +1,90 @@
+package discovery
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newFakeTenant returns a server answering StartAuthentication with the given PodFqdn
+func newFakeTenant(t *testing.T, podFqdn string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, startAuthEndpoint) {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+
+		var req map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req["User"] == "" {
+			t.Errorf("Expected StartAuthentication body with User, got %v (%v)", req, err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"success": true, "Result": {"PodFqdn": "` + podFqdn + `"}}`))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestResolveTenantURL(t *testing.T) {
+	pod := newFakeTenant(t, "")
+	discoveryPod := newFakeTenant(t, pod.URL)
+
+	origDiscoveryURL, origTemplate := DiscoveryURL, TenantURLTemplate
+	defer func() {
+		DiscoveryURL, TenantURLTemplate = origDiscoveryURL, origTemplate
+	}()
+	DiscoveryURL = discoveryPod.URL
+	TenantURLTemplate = discoveryPod.URL + "/tenants/%s"
+
+	tests := []struct {
+		name     string
+		tenant   string
+		username string
+		expected string
+	}{
+		{"Full URL", "https://abc1234.id.cyberark.cloud/", "user@example.com", "https://abc1234.id.cyberark.cloud"},
+		{"Hostname", "abc1234.id.cyberark.cloud", "", "https://abc1234.id.cyberark.cloud"},
+		{"Tenant ID without username", "abc1234", "", discoveryPod.URL + "/tenants/abc1234"},
+		{"Tenant ID with redirect", "abc1234", "user@example.com", pod.URL},
+		{"Login suffix", "", "user@example.com", pod.URL},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ResolveTenantURL(tt.tenant, tt.username)
+			if err != nil {
+				t.Fatalf("ResolveTenantURL failed: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("ResolveTenantURL(%q, %q) = %q, want %q", tt.tenant, tt.username, result, tt.expected)
+			}
+		})
+	}
+
+	if _, err := ResolveTenantURL("", "user-without-suffix"); err == nil {
+		t.Error("Expected error when neither tenant nor login suffix is available")
+	}
+}
+
+func TestIsTenantURL(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"abc1234", false},
+		{"https://abc1234.id.cyberark.cloud", true},
+		{"abc1234.id.cyberark.cloud", true},
+		{"localhost", true},
+		{"localhost:8080", true},
+		{"identity-host:8443", true},
+	}
+
+	for _, tt := range tests {
+		if result := IsTenantURL(tt.input); result != tt.expected {
+			t.Errorf("IsTenantURL(%q) = %v, want %v", tt.input, result, tt.expected)
+		}
+	}
+}
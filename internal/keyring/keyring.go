@@ -0,0 +1,115 @@
+package keyring
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// ErrNotFound is returned when the keyring has no such secret
+var ErrNotFound = errors.New("secret not found in keyring")
+
+// ErrUnsupported is returned when no keyring tool is available
+var ErrUnsupported = errors.New("no keyring available (macOS Keychain or secret-tool from libsecret is required)")
+
+// Get reads a secret from the system keyring: the Keychain on macOS and the
+// Secret Service (through secret-tool) elsewhere
+func Get(service, account string) (string, error) {
+	if runtime.GOOS == "darwin" {
+		output, err := run("security", "", "find-generic-password", "-s", service, "-a", account, "-w")
+		if err != nil {
+			// security exits with 44 when the item does not exist
+			if exitCode(err) == 44 {
+				return "", ErrNotFound
+			}
+			return "", err
+		}
+		return strings.TrimSuffix(output, "\n"), nil
+	}
+
+	output, err := run("secret-tool", "", "lookup", "service", service, "account", account)
+	if err != nil {
+		// secret-tool exits with 1 and prints nothing when the item does not exist
+		if exitCode(err) == 1 && output == "" {
+			return "", ErrNotFound
+		}
+		return "", err
+	}
+	return output, nil
+}
+
+// Set stores a secret in the system keyring, replacing any existing one. The
+// secret is passed on stdin so that it never appears in a process listing.
+func Set(service, account, secret string) error {
+	if runtime.GOOS == "darwin" {
+		command := fmt.Sprintf("add-generic-password -U -s %q -a %q -w %q\n", service, account, secret)
+		_, err := run("security", command, "-i")
+		return err
+	}
+
+	_, err := run("secret-tool", secret, "store", "--label="+service, "service", service, "account", account)
+	return err
+}
+
+// Delete removes a secret from the system keyring. A missing secret is not
+// an error.
+func Delete(service, account string) error {
+	if runtime.GOOS == "darwin" {
+		_, err := run("security", "", "delete-generic-password", "-s", service, "-a", account)
+		if exitCode(err) == 44 {
+			return nil
+		}
+		return err
+	}
+
+	_, err := run("secret-tool", "", "clear", "service", service, "account", account)
+	return err
+}
+
+// run runs a keyring tool with input on stdin and returns its output
+func run(tool, input string, args ...string) (string, error) {
+	if runtime.GOOS == "windows" {
+		return "", ErrUnsupported
+	}
+	path, err := exec.LookPath(tool)
+	if err != nil {
+		return "", ErrUnsupported
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(path, args...)
+	cmd.Stdin = strings.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return stdout.String(), &toolError{tool: tool, err: err, stderr: strings.TrimSpace(stderr.String())}
+	}
+	return stdout.String(), nil
+}
+
+// exitCode returns the exit status of a keyring tool that failed, or -1
+func exitCode(err error) int {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+// toolError describes a keyring tool that failed
+type toolError struct {
+	tool   string
+	err    error
+	stderr string
+}
+
+func (e *toolError) Error() string {
+	return fmt.Sprintf("%s failed: %s %s", e.tool, e.err, e.stderr)
+}
+
+func (e *toolError) Unwrap() error {
+	return e.err
+}
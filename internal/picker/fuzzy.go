@@ -0,0 +1,81 @@
+package picker
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Score bonuses for fuzzy matches
+const (
+	bonusConsecutive = 5
+	bonusWordStart   = 3
+	bonusFirstChar   = 2
+)
+
+// Match reports whether every character of query appears in candidate in
+// order, ignoring case, and scores the match. Matches at word starts and runs
+// of consecutive characters score higher; gaps score lower.
+func Match(query, candidate string) (int, bool) {
+	if query == "" {
+		return 0, true
+	}
+
+	q := []rune(strings.ToLower(query))
+	c := []rune(candidate)
+	lower := []rune(strings.ToLower(candidate))
+
+	score, qi, last := 0, 0, -1
+	for ci := 0; ci < len(lower) && qi < len(q); ci++ {
+		if lower[ci] != q[qi] {
+			continue
+		}
+
+		score++
+		switch {
+		case ci == 0:
+			score += bonusFirstChar + bonusWordStart
+		case !unicode.IsLetter(c[ci-1]) && !unicode.IsDigit(c[ci-1]),
+			unicode.IsUpper(c[ci]) && unicode.IsLower(c[ci-1]):
+			score += bonusWordStart
+		}
+		if last >= 0 && ci == last+1 {
+			score += bonusConsecutive
+		} else if last >= 0 {
+			score -= ci - last - 1
+		}
+
+		last = ci
+		qi++
+	}
+
+	if qi < len(q) {
+		return 0, false
+	}
+	return score, true
+}
+
+// Filter returns the indexes of the candidates matching query, best first.
+// Equal scores keep their original order.
+func Filter(query string, candidates []string) []int {
+	type scored struct {
+		index int
+		score int
+	}
+
+	var matches []scored
+	for i, candidate := range candidates {
+		if score, ok := Match(query, candidate); ok {
+			matches = append(matches, scored{i, score})
+		}
+	}
+	sort.SliceStable(matches, func(a, b int) bool {
+		return matches[a].score > matches[b].score
+	})
+
+	indexes := make([]int, len(matches))
+	for i, match := range matches {
+		indexes[i] = match.index
+	}
+	return indexes
+}
@@ -0,0 +1,190 @@
+package picker
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+)
+
+// maxVisible is how many matches are shown at once
+const maxVisible = 10
+
+// ErrCancelled is returned when the user leaves the picker without choosing
+var ErrCancelled = errors.New("selection cancelled")
+
+// Key sequences read from the terminal in raw mode
+const (
+	keyEnter     = "\r"
+	keyCtrlC     = "\x03"
+	keyCtrlN     = "\x0e"
+	keyCtrlP     = "\x10"
+	keyCtrlU     = "\x15"
+	keyEscape    = "\x1b"
+	keyBackspace = "\x7f"
+	keyCtrlH     = "\x08"
+	keyUp        = "\x1b[A"
+	keyDown      = "\x1b[B"
+	keyUpSS3     = "\x1bOA"
+	keyDownSS3   = "\x1bOB"
+)
+
+// picker is the state of one fuzzy selection
+type picker struct {
+	prompt   string
+	items    []string
+	query    []rune
+	matches  []int
+	selected int
+	lines    int
+	out      io.Writer
+}
+
+// Pick shows a fuzzy-searchable list of items on the terminal and returns the
+// index of the chosen one. Input is read from stdin and the list is drawn on
+// stderr, keeping stdout free for the result.
+func Pick(prompt string, items []string) (int, error) {
+	if len(items) == 0 {
+		return 0, errors.New("nothing to choose from")
+	}
+
+	fd := int(os.Stdin.Fd())
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return 0, fmt.Errorf("error preparing terminal: %s", err)
+	}
+	defer term.Restore(fd, state)
+
+	p := &picker{prompt: prompt, items: items, out: os.Stderr}
+	p.update()
+	defer p.clear()
+
+	buf := make([]byte, 64)
+	for {
+		p.render()
+
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			return 0, fmt.Errorf("error reading input: %s", err)
+		}
+
+		// A single read may hold several keys, e.g. pasted text
+		for _, key := range splitKeys(buf[:n]) {
+			if index, done, err := p.handle(key); done {
+				return index, err
+			}
+		}
+	}
+}
+
+// splitKeys splits terminal input into key presses, keeping escape
+// sequences and multi-byte characters whole
+func splitKeys(input []byte) []string {
+	var keys []string
+	for len(input) > 0 {
+		size := 1
+		switch {
+		case input[0] == 0x1b && len(input) > 2 && input[1] == '[':
+			// CSI: parameters up to a final byte in 0x40-0x7e
+			size = 2
+			for size < len(input) && (input[size] < 0x40 || input[size] > 0x7e) {
+				size++
+			}
+			if size < len(input) {
+				size++
+			}
+		case input[0] == 0x1b && len(input) > 2 && input[1] == 'O':
+			// SS3: a single final byte
+			size = 3
+		case input[0] == 0x1b && len(input) > 1:
+			// Alt together with a key
+			_, width := utf8.DecodeRune(input[1:])
+			size = 1 + width
+		default:
+			_, size = utf8.DecodeRune(input)
+		}
+		keys = append(keys, string(input[:size]))
+		input = input[size:]
+	}
+	return keys
+}
+
+// handle applies one key press. It returns done when the picker should close.
+func (p *picker) handle(key string) (int, bool, error) {
+	switch key {
+	case keyEnter:
+		if len(p.matches) == 0 {
+			return 0, false, nil
+		}
+		return p.matches[p.selected], true, nil
+	case keyCtrlC, keyEscape:
+		return 0, true, ErrCancelled
+	case keyUp, keyUpSS3, keyCtrlP:
+		if p.selected > 0 {
+			p.selected--
+		}
+	case keyDown, keyDownSS3, keyCtrlN:
+		if p.selected < len(p.matches)-1 {
+			p.selected++
+		}
+	case keyBackspace, keyCtrlH:
+		if len(p.query) > 0 {
+			p.query = p.query[:len(p.query)-1]
+			p.update()
+		}
+	case keyCtrlU:
+		p.query = nil
+		p.update()
+	default:
+		// Ignore other control keys and escape sequences
+		if key[0] < 0x20 || key[0] == 0x1b {
+			return 0, false, nil
+		}
+		p.query = append(p.query, []rune(key)...)
+		p.update()
+	}
+	return 0, false, nil
+}
+
+// update filters the items for the current query
+func (p *picker) update() {
+	p.matches = Filter(string(p.query), p.items)
+	p.selected = 0
+}
+
+// render redraws the prompt and the visible matches in place
+func (p *picker) render() {
+	p.clear()
+
+	fmt.Fprintf(p.out, "%s%s\r\n", p.prompt, string(p.query))
+	p.lines = 1
+
+	// Scroll so the selection stays visible
+	start := 0
+	if p.selected >= maxVisible {
+		start = p.selected - maxVisible + 1
+	}
+	for i := start; i < len(p.matches) && i < start+maxVisible; i++ {
+		marker := "  "
+		if i == p.selected {
+			marker = "> "
+		}
+		fmt.Fprintf(p.out, "%s%s\r\n", marker, p.items[p.matches[i]])
+		p.lines++
+	}
+
+	fmt.Fprintf(p.out, "  %d/%d (up/down to move, enter to choose, esc to cancel)\r\n", len(p.matches), len(p.items))
+	p.lines++
+}
+
+// clear erases what the last render drew
+func (p *picker) clear() {
+	if p.lines > 0 {
+		fmt.Fprintf(p.out, "\x1b[%dA", p.lines)
+	}
+	fmt.Fprint(p.out, "\r\x1b[J")
+	p.lines = 0
+}
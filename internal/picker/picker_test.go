@@ -0,0 +1,102 @@
+package picker
+
+import (
+	"io"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		query     string
+		candidate string
+		matches   bool
+	}{
+		{"", "anything", true},
+		{"ordb", "Oracle Database", true},
+		{"ORACLE", "oracle database", true},
+		{"dbo", "Oracle Database", false},
+		{"xyz", "Oracle Database", false},
+	}
+
+	for _, tt := range tests {
+		if _, ok := Match(tt.query, tt.candidate); ok != tt.matches {
+			t.Errorf("Match(%q, %q) = %v, want %v", tt.query, tt.candidate, ok, tt.matches)
+		}
+	}
+}
+
+func TestFilterRanksBetterMatchesFirst(t *testing.T) {
+	candidates := []string{
+		"Production Oracle Database",
+		"Dev Box",
+		"db-backup",
+		"Oracle DB",
+	}
+
+	got := Filter("db", candidates)
+	// Consecutive matches at a word start beat scattered ones
+	want := []int{2, 3, 1, 0}
+	if len(got) != len(want) {
+		t.Fatalf("Filter = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Filter = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestPickerHandle(t *testing.T) {
+	p := &picker{items: []string{"alpha", "beta", "gamma"}, out: io.Discard}
+	p.update()
+
+	for _, key := range []string{"a", keyDown, keyDown, keyDown, keyUp} {
+		if _, done, _ := p.handle(key); done {
+			t.Fatalf("Picker closed on key %q", key)
+		}
+	}
+
+	// "a" matches all three; the selection moved to the second match
+	index, done, err := p.handle(keyEnter)
+	if !done || err != nil || index != p.matches[1] {
+		t.Errorf("Enter = %d, %v, %v; want match %d", index, done, err, p.matches[1])
+	}
+
+	p.handle(keyBackspace)
+	p.handle("z")
+	if _, done, _ := p.handle(keyEnter); done {
+		t.Error("Enter with no matches closed the picker")
+	}
+
+	if _, done, err := p.handle(keyEscape); !done || err != ErrCancelled {
+		t.Errorf("Escape = %v, %v; want ErrCancelled", done, err)
+	}
+}
+
+func TestSplitKeys(t *testing.T) {
+	tests := []struct {
+		input string
+		keys  []string
+	}{
+		{"abc", []string{"a", "b", "c"}},
+		{"ä\r", []string{"ä", keyEnter}},
+		{"\x1b[B\x1b[Bx", []string{keyDown, keyDown, "x"}},
+		{"\x1bOA\x1b[1;5C", []string{keyUpSS3, "\x1b[1;5C"}},
+		{"\x1b", []string{keyEscape}},
+		{"\x1bx", []string{"\x1bx"}},
+	}
+
+	for _, tt := range tests {
+		keys := splitKeys([]byte(tt.input))
+		if len(keys) != len(tt.keys) {
+			t.Errorf("splitKeys(%q) = %q, want %q", tt.input, keys, tt.keys)
+			continue
+		}
+		for i := range keys {
+			if keys[i] != tt.keys[i] {
+				t.Errorf("splitKeys(%q) = %q, want %q", tt.input, keys, tt.keys)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package provider
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/infamousjoeg/summon-wpm/internal/agent"
+)
+
+// DefaultWorkers is how many credentials are fetched at once in a batch
+const DefaultWorkers = 8
+
+// MaxWorkers caps the workers of a batch, as an agent does for its clients
+const MaxWorkers = agent.MaxWorkers
+
+// ErrSkipped marks batch items not fetched because an earlier one failed
+var ErrSkipped = errors.New("skipped after an earlier failure")
+
+// BatchResult is the outcome of one item of a batch. A value served from the
+// cache because the tenant could not be reached comes with a
+// *cache.StaleError.
+type BatchResult struct {
+	ID    string
+	Value string
+	Err   error
+}
+
+// GetCredentials fetches many credentials concurrently with one config load
+// and one shared token, logging in only if some are not cached. Results are
+// in the order of ids. A failed item does not stop the others unless
+// failFast is set, in which case the items not yet started fail with
+// ErrSkipped. At most MaxWorkers credentials are fetched at once.
+func (p *Provider) GetCredentials(ids []string, workers int, failFast bool) ([]BatchResult, error) {
+	if p.agent != nil {
+		return p.agentCredentials(ids, workers, failFast)
+	}
+
+	s, err := p.open()
+	if err != nil {
+		return nil, err
+	}
+	// Serve what the cache has and log in only for the rest
+	results := make([]BatchResult, len(ids))
+	cache := p.cacheFor(s)
+	var pending []int
+	for i, id := range ids {
+		if value, ok := cache.lookup(id); ok {
+			results[i] = BatchResult{ID: id, Value: value}
+			continue
+		}
+		pending = append(pending, i)
+	}
+	if len(pending) == 0 {
+		return results, nil
+	}
+	if err := s.prepare(); err != nil {
+		return p.fallbackBatch(cache, ids, pending, results, err)
+	}
+
+	// No more workers than there are credentials to fetch
+	if workers < 1 {
+		workers = DefaultWorkers
+	}
+	if workers > MaxWorkers {
+		workers = MaxWorkers
+	}
+	if workers > len(pending) {
+		workers = len(pending)
+	}
+
+	var failed sync.Once
+	stop := make(chan struct{})
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i].ID = ids[i]
+				select {
+				case <-stop:
+					results[i].Err = ErrSkipped
+					continue
+				default:
+				}
+
+				results[i].Value, results[i].Err = s.fetch(ids[i])
+				if results[i].Err == nil {
+					cache.store(ids[i], results[i].Value)
+				} else {
+					results[i].Value, results[i].Err = cache.fallback(ids[i], results[i].Err)
+				}
+				if results[i].Err != nil && !isStale(results[i].Err) && failFast {
+					failed.Do(func() { close(stop) })
+				}
+			}
+		}()
+	}
+
+	for _, i := range pending {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results, nil
+}
+
+// fallbackBatch answers the pending items from the cache when logging in
+// failed. Unless the cache could serve some of them, the batch fails with
+// the login error as a whole.
+func (p *Provider) fallbackBatch(cache *credentialCache, ids []string, pending []int, results []BatchResult, err error) ([]BatchResult, error) {
+	served := false
+	for _, i := range pending {
+		results[i].ID = ids[i]
+		results[i].Value, results[i].Err = cache.fallback(ids[i], err)
+		if isStale(results[i].Err) {
+			served = true
+		}
+	}
+	if !served {
+		return nil, err
+	}
+	return results, nil
+}
+
+// agentCredentials sends a batch to the agent
+func (p *Provider) agentCredentials(ids []string, workers int, failFast bool) ([]BatchResult, error) {
+	agentResults, err := p.agent.GetCredentials(agent.CredentialsRequest{IDs: ids, Workers: workers, FailFast: failFast, NoCache: p.noCache})
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]BatchResult, len(agentResults))
+	for i, result := range agentResults {
+		results[i] = BatchResult{ID: result.ID, Value: result.Value}
+		switch {
+		case result.Stale != nil:
+			warnStale(result.Stale)
+			results[i].Err = result.Stale
+		case result.Error != "":
+			results[i].Err = errors.New(result.Error)
+		}
+	}
+	return results, nil
+}
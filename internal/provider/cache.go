@@ -0,0 +1,179 @@
+package provider
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/infamousjoeg/summon-wpm/internal/cache"
+	"github.com/infamousjoeg/summon-wpm/internal/config"
+	"github.com/infamousjoeg/summon-wpm/internal/selector"
+)
+
+// keyringKey returns the cache key of CLI runs; replaced in tests
+var keyringKey = cache.KeyringKey
+
+// credentialCache serves credentials from the local cache within each app's
+// TTL. Cache problems are reported but never fail a lookup. A nil cache
+// caches nothing.
+type credentialCache struct {
+	cache    *cache.Cache
+	settings *config.CacheConfig
+	verbose  bool
+}
+
+// openCache opens the profile's cache when its config turns caching on. The
+// cache is scoped to the tenant and identity so that profiles never share
+// entries. A nil key uses the key in the system keyring.
+func (p *Provider) openCache(cfg *config.Config, key []byte) *credentialCache {
+	if cfg.Cache == nil {
+		return nil
+	}
+
+	identity := cacheIdentity(cfg)
+	if identity == "" {
+		fmt.Fprintln(os.Stderr, "Warning: credential cache disabled: the profile has no username or client ID to scope it to")
+		return nil
+	}
+
+	if key == nil {
+		if p.noCache {
+			return nil
+		}
+		var err error
+		if key, err = keyringKey(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: credential cache disabled: %s\n", err)
+			return nil
+		}
+	}
+
+	c, err := cache.New(cache.Dir(), cfg.TenantURL+"\x00"+identity, key)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: credential cache disabled: %s\n", err)
+		return nil
+	}
+	return &credentialCache{cache: c, settings: cfg.Cache, verbose: p.verbose}
+}
+
+// cacheIdentity names who the profile fetches credentials as
+func cacheIdentity(cfg *config.Config) string {
+	for _, identity := range []string{cfg.Username, cfg.OAuthClientID, cfg.ClientID, cfg.ClientCertFile, cfg.SubjectTokenSource} {
+		if identity != "" {
+			return identity
+		}
+	}
+	return ""
+}
+
+// cacheFor returns the session's cache unless p bypasses it
+func (p *Provider) cacheFor(s *sharedSession) *credentialCache {
+	if p.noCache {
+		return nil
+	}
+	return s.cache
+}
+
+// policy returns how long the credential is served from the cache and how
+// old it may be when used because the tenant cannot be reached. Both are 0
+// when the credential is not cached.
+func (c *credentialCache) policy(id string) (ttl, maxStale time.Duration) {
+	appID, _ := selector.Parse(id)
+	ttl, err := c.settings.AppTTL(appID)
+	if err == nil {
+		maxStale, err = c.settings.AppMaxStale(appID)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %s; not caching it\n", err)
+		return 0, 0
+	}
+	return ttl, maxStale
+}
+
+// lookup returns the cached credential when it is within its TTL
+func (c *credentialCache) lookup(id string) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	ttl, maxStale := c.policy(id)
+	if ttl == 0 && maxStale == 0 {
+		return "", false
+	}
+
+	entry, err := c.cache.Get(id)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: error reading credential cache: %s\n", err)
+		return "", false
+	}
+	if entry == nil {
+		return "", false
+	}
+
+	age := time.Since(entry.FetchedAt)
+	if age > ttl {
+		// Expired credentials are only kept at rest as a stale-if-error fallback
+		if age > maxStale {
+			c.cache.Delete(id)
+		}
+		return "", false
+	}
+
+	if c.verbose {
+		fmt.Fprintf(os.Stderr, "Using cached credential for %s (fetched %s ago)\n", id, age.Round(time.Second))
+	}
+	return entry.Value, true
+}
+
+// store caches a freshly fetched credential
+func (c *credentialCache) store(id, value string) {
+	if c == nil {
+		return
+	}
+	// lookup has already reported an invalid setting
+	appID, _ := selector.Parse(id)
+	ttl, err := c.settings.AppTTL(appID)
+	if err != nil {
+		return
+	}
+	maxStale, err := c.settings.AppMaxStale(appID)
+	if err != nil || ttl == 0 && maxStale == 0 {
+		return
+	}
+	if err := c.cache.Put(id, value); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: error writing credential cache: %s\n", err)
+	}
+}
+
+// fallback serves the last fetched credential when fetching failed because
+// the tenant could not be reached and the app's stale-if-error policy allows
+// an entry that old. The value comes with a *cache.StaleError; otherwise the
+// original error is returned.
+func (c *credentialCache) fallback(id string, fetchErr error) (string, error) {
+	if c == nil || !isUnavailableError(fetchErr) {
+		return "", fetchErr
+	}
+	_, maxStale := c.policy(id)
+	if maxStale == 0 {
+		return "", fetchErr
+	}
+
+	entry, err := c.cache.Get(id)
+	if err != nil || entry == nil {
+		return "", fetchErr
+	}
+	if age := time.Since(entry.FetchedAt); age > maxStale {
+		if c.verbose {
+			fmt.Fprintf(os.Stderr, "Cached credential for %s is %s old, older than the %s allowed\n",
+				id, age.Round(time.Second), maxStale)
+		}
+		return "", fetchErr
+	}
+
+	stale := &cache.StaleError{ID: id, FetchedAt: entry.FetchedAt, Reason: fetchErr.Error()}
+	warnStale(stale)
+	return entry.Value, stale
+}
+
+// warnStale reports a credential served from the cache
+func warnStale(err error) {
+	fmt.Fprintf(os.Stderr, "Warning: %s\n", err)
+}
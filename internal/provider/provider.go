@@ -1,100 +1,302 @@
 package provider
 
 import (
+	"errors"
 	"fmt"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
 	"strings"
 
+	"github.com/infamousjoeg/summon-wpm/internal/agent"
+	"github.com/infamousjoeg/summon-wpm/internal/api"
 	"github.com/infamousjoeg/summon-wpm/internal/auth"
+	"github.com/infamousjoeg/summon-wpm/internal/cache"
 	"github.com/infamousjoeg/summon-wpm/internal/config"
 )
 
+// NoCache is set by --no-cache to bypass the credential cache
+var NoCache bool
+
 // Provider represents the Summon provider for CyberArk Identity
 type Provider struct {
 	verbose bool
+
+	// noCache bypasses the credential cache, reading and writing
+	noCache bool
+
+	// agent answers lookups instead when SUMMON_WPM_AGENT_SOCK is set
+	agent *agent.Client
+
+	// memory is the session an agent holds; it is never saved
+	memory *sharedSession
 }
 
-// NewProvider creates a new provider instance
+// NewProvider creates a new provider instance. When SUMMON_WPM_AGENT_SOCK is
+// set, lookups are sent to the agent listening there.
 func NewProvider(verbose bool) *Provider {
-	return &Provider{
+	p := &Provider{
 		verbose: verbose,
+		noCache: NoCache,
+	}
+	if socket := os.Getenv(agent.SocketEnvVar); socket != "" {
+		p.agent = agent.NewClient(socket)
+	}
+	return p
+}
+
+// NewMemoryProvider creates the provider an agent serves from. It logs in
+// now, while the user is at the terminal, and keeps the session in memory
+// without writing tokens to the config file. Cached credentials are
+// encrypted with a key that only the agent's memory holds.
+func NewMemoryProvider(verbose bool) (*Provider, error) {
+	cfg, err := loadConfig(config.GetConfigFilePath())
+	if err != nil {
+		return nil, err
+	}
+	if err := config.ResolveTenant(cfg, ""); err != nil {
+		return nil, fmt.Errorf("error resolving tenant: %s", err)
+	}
+
+	p := &Provider{verbose: verbose, noCache: NoCache}
+	p.memory = &sharedSession{provider: p, cfg: cfg}
+	p.memory.cache = p.openCache(cfg, cache.NewKey())
+	if err := p.memory.prepare(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// GetCredential retrieves a credential from CyberArk Identity. The ID may
+// select a field other than the password, e.g. "my-app#Username"; see
+// selector.Format for passing a plain app ID. When the
+// tenant cannot be reached and the app allows it, the last fetched value is
+// returned from the cache along with a *cache.StaleError.
+func (p *Provider) GetCredential(id string) (string, error) {
+	if p.agent != nil {
+		value, err := p.agent.GetCredential(id, p.noCache)
+		if isStale(err) {
+			warnStale(err)
+		}
+		return value, err
+	}
+
+	s, err := p.open()
+	if err != nil {
+		return "", err
+	}
+	cache := p.cacheFor(s)
+	if value, ok := cache.lookup(id); ok {
+		return value, nil
+	}
+
+	if err := s.prepare(); err != nil {
+		return cache.fallback(id, err)
+	}
+	value, err := s.fetch(id)
+	if err != nil {
+		return cache.fallback(id, err)
+	}
+	cache.store(id, value)
+	return value, nil
+}
+
+// WithoutCache returns a provider sharing p's session that bypasses the
+// credential cache
+func (p *Provider) WithoutCache() *Provider {
+	uncached := *p
+	uncached.noCache = true
+	return &uncached
+}
+
+// ListApps lists the applications available to the current identity
+func (p *Provider) ListApps(filter string) ([]auth.App, error) {
+	if p.agent != nil {
+		return p.agent.ListApps(filter)
+	}
+
+	s, err := p.shared()
+	if err != nil {
+		return nil, err
+	}
+
+	var apps []auth.App
+	err = s.do(func(cfg *config.Config) error {
+		apps, err = auth.ListApps(cfg, filter)
+		return err
+	})
+	return apps, err
+}
+
+// Status reports the session an agent holds
+func (p *Provider) Status() *auth.Status {
+	if p.memory == nil {
+		return nil
+	}
+
+	p.memory.lock.RLock()
+	defer p.memory.lock.RUnlock()
+	return auth.GetStatus(p.memory.cfg, config.ActiveProfile())
+}
+
+// KeepAlive renews the session an agent holds before its token expires. A
+// refresh token or service credentials renew it silently; an interactive
+// session cannot be renewed in the background and is reported once.
+func (p *Provider) KeepAlive() error {
+	if p.memory == nil {
+		return nil
+	}
+	return p.memory.renew()
+}
+
+// shared returns the logged-in session for a lookup: the agent's, or else
+// one loaded from the config file
+func (p *Provider) shared() (*sharedSession, error) {
+	s, err := p.open()
+	if err != nil {
+		return nil, err
 	}
+	return s, s.prepare()
 }
 
-// GetCredential retrieves a credential from CyberArk Identity
-func (p *Provider) GetCredential(appID string) (string, error) {
+// open returns the session without logging in, so that cached credentials
+// can be served first
+func (p *Provider) open() (*sharedSession, error) {
+	if p.memory != nil {
+		return p.memory, nil
+	}
+
 	configFile := config.GetConfigFilePath()
+	cfg, err := loadConfig(configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	// Resolve a tenant ID or login suffix to a tenant URL on first use
+	if err := config.ResolveTenant(cfg, configFile); err != nil {
+		return nil, fmt.Errorf("error resolving tenant: %s", err)
+	}
+
+	s := &sharedSession{provider: p, cfg: cfg, configFile: configFile}
+	s.cache = p.openCache(cfg, nil)
+	return s, nil
+}
 
+// loadConfig loads the profile's config file
+func loadConfig(configFile string) (*config.Config, error) {
 	cfg, err := config.LoadConfig(configFile)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return "", fmt.Errorf("no configuration found. Run with --config to set up")
+			return nil, fmt.Errorf("no configuration found. Run with --config to set up")
 		}
-		return "", fmt.Errorf("error loading config: %s", err)
+		return nil, fmt.Errorf("error loading config: %s", err)
 	}
+	return cfg, nil
+}
 
+// authenticate logs in when the profile has no usable token
+func (p *Provider) authenticate(cfg *config.Config, configFile string) error {
 	// Check if we need to authenticate or refresh token
 	needAuth := auth.NeedsAuthentication(cfg)
 	interactive := auth.IsInteractive()
 
+	// A refresh token from a browser login renews the session silently
+	if needAuth && cfg.RefreshToken != "" {
+		if err := auth.RefreshAccessToken(cfg, configFile); err != nil {
+			// Logging in another way cannot work while the tenant is down,
+			// and the outage lets the cache serve the credential
+			if isUnavailableError(err) {
+				return fmt.Errorf("token refresh failed: %w", err)
+			}
+			if p.verbose {
+				fmt.Fprintf(os.Stderr, "Token refresh failed: %s\n", err)
+			}
+		} else {
+			needAuth = false
+		}
+	}
+
 	if needAuth {
 		if p.verbose {
 			fmt.Fprintln(os.Stderr, "Authentication required, authenticating...")
 		}
 
-		if cfg.ClientID != "" && cfg.ClientSecret != "" {
+		if auth.HasServiceCredentials(cfg) {
 			// Non-interactive service user auth
-			if err := auth.AuthenticateWithClientCredentials(cfg, configFile); err != nil {
+			if err := auth.AuthenticateService(cfg, configFile); err != nil {
 				if p.verbose {
 					fmt.Fprintf(os.Stderr, "Service user authentication failed: %s\n", err)
 				}
 
 				if interactive {
 					// Fallback to interactive if running in terminal
-					if err := auth.AuthenticateInteractive(cfg, configFile); err != nil {
-						return "", fmt.Errorf("interactive authentication failed: %s", err)
+					if err := auth.AuthenticateInteractive(cfg, configFile, auth.InteractiveOptions{}); err != nil {
+						return fmt.Errorf("interactive authentication failed: %w", err)
 					}
 				} else {
-					return "", fmt.Errorf("service user authentication failed: %s", err)
+					return fmt.Errorf("service user authentication failed: %w", err)
 				}
 			}
 		} else if interactive {
 			// Interactive user auth
-			if err := auth.AuthenticateInteractive(cfg, configFile); err != nil {
-				return "", fmt.Errorf("authentication failed: %s", err)
+			if err := auth.AuthenticateInteractive(cfg, configFile, auth.InteractiveOptions{}); err != nil {
+				return fmt.Errorf("authentication failed: %w", err)
 			}
 		} else {
-			return "", fmt.Errorf("authentication required but running in non-interactive mode with no service credentials")
+			return errors.New("authentication required but running in non-interactive mode with no service credentials")
 		}
 	}
 
-	// Get app credentials
-	credential, err := auth.GetAppCredentials(cfg, appID)
-	if err != nil {
-		// If we get an auth error, try to re-authenticate once
-		if strings.Contains(err.Error(), "authentication") || strings.Contains(err.Error(), "401") {
-			if p.verbose {
-				fmt.Fprintln(os.Stderr, "Authentication token expired or invalid, re-authenticating...")
-			}
+	return nil
+}
 
-			if cfg.ClientID != "" && cfg.ClientSecret != "" {
-				if err := auth.AuthenticateWithClientCredentials(cfg, configFile); err != nil {
-					return "", fmt.Errorf("re-authentication failed: %s", err)
-				}
-			} else if interactive {
-				if err := auth.AuthenticateInteractive(cfg, configFile); err != nil {
-					return "", fmt.Errorf("re-authentication failed: %s", err)
-				}
-			} else {
-				return "", fmt.Errorf("re-authentication required but running in non-interactive mode")
-			}
+// isAuthError reports whether the tenant rejected the token
+func isAuthError(err error) bool {
+	return strings.Contains(err.Error(), "authentication") || strings.Contains(err.Error(), "401")
+}
 
-			// Try again with new token
-			return auth.GetAppCredentials(cfg, appID)
-		}
+// isUnavailableError reports whether the tenant could not be reached or could
+// not answer, as opposed to refusing the request: a network error, a timeout
+// or a 5xx response
+func isUnavailableError(err error) bool {
+	var statusErr *api.StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= http.StatusInternalServerError
+	}
 
-		return "", err
+	// Look inside the client's *url.Error, which wraps certificate and other
+	// non-network failures as well
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// isStale reports whether err marks a credential served from the cache
+func isStale(err error) bool {
+	var stale *cache.StaleError
+	return errors.As(err, &stale)
+}
+
+// reauthenticate replaces a token the tenant rejected
+func (p *Provider) reauthenticate(cfg *config.Config, configFile string) error {
+	if p.verbose {
+		fmt.Fprintln(os.Stderr, "Authentication token expired or invalid, re-authenticating...")
 	}
 
-	return credential, nil
+	if auth.HasServiceCredentials(cfg) {
+		if err := auth.AuthenticateService(cfg, configFile); err != nil {
+			return fmt.Errorf("re-authentication failed: %w", err)
+		}
+	} else if auth.IsInteractive() {
+		if err := auth.AuthenticateInteractive(cfg, configFile, auth.InteractiveOptions{}); err != nil {
+			return fmt.Errorf("re-authentication failed: %w", err)
+		}
+	} else {
+		return fmt.Errorf("re-authentication required but running in non-interactive mode")
+	}
+	return nil
 }
@@ -1,13 +1,21 @@
 package provider
 
 import (
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/infamousjoeg/summon-wpm/internal/api"
+	"github.com/infamousjoeg/summon-wpm/internal/auth"
+	"github.com/infamousjoeg/summon-wpm/internal/cache"
 	"github.com/infamousjoeg/summon-wpm/internal/config"
 )
 
@@ -130,3 +138,403 @@ func TestGetCredentialWithNoConfig(t *testing.T) {
 		t.Errorf("Expected non-existence error message, got: %v", err)
 	}
 }
+
+func TestGetCredentials(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "summon-wpm-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	configFile := filepath.Join(tmpDir, "test-config.json")
+
+	origGetConfigFilePath := config.GetConfigFilePath
+	defer func() {
+		config.GetConfigFilePath = origGetConfigFilePath
+	}()
+	config.GetConfigFilePath = func() string {
+		return configFile
+	}
+
+	var lock sync.Mutex
+	tokenRequests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		switch r.URL.Path {
+		case auth.TokenEndpoint:
+			tokenRequests++
+			w.Write([]byte(`{"access_token": "new-token", "token_type": "Bearer", "expires_in": 3600}`))
+		case auth.GetAppCredsEndpoint:
+			// The stored token has been revoked on the tenant
+			if r.Header.Get("Authorization") != "Bearer new-token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			appKey := r.URL.Query().Get("appkey")
+			if appKey == "missing" {
+				w.Write([]byte(`{"Result": {}}`))
+				return
+			}
+			fmt.Fprintf(w, `{"Result": {"Username": "user-%s", "Password": "secret-%s"}}`, appKey, appKey)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		TenantURL:    server.URL,
+		ClientID:     "test-client-id",
+		ClientSecret: "test-client-secret",
+		AuthToken:    "revoked-token",
+		TokenExpiry:  time.Now().Add(1 * time.Hour).Unix(),
+	}
+	if err := config.SaveConfig(cfg, configFile); err != nil {
+		t.Fatalf("Failed to save config: %v", err)
+	}
+
+	ids := []string{"app-1", "app-2#Username", "missing", "app-3", "app-4", "app-5"}
+	results, err := NewProvider(false).GetCredentials(ids, 3, false)
+	if err != nil {
+		t.Fatalf("GetCredentials failed: %v", err)
+	}
+
+	expected := []string{"secret-app-1", "user-app-2", "", "secret-app-3", "secret-app-4", "secret-app-5"}
+	for i, result := range results {
+		if result.ID != ids[i] || result.Value != expected[i] {
+			t.Errorf("Result %d = %+v, want %s", i, result, expected[i])
+		}
+		if (result.Err != nil) != (ids[i] == "missing") {
+			t.Errorf("Result %d error = %v", i, result.Err)
+		}
+	}
+
+	// All workers share the one replacement token
+	if tokenRequests != 1 {
+		t.Errorf("Expected 1 token request, got %d", tokenRequests)
+	}
+}
+
+func TestMemoryProviderKeepsTokensOffDisk(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "summon-wpm-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	configFile := filepath.Join(tmpDir, "test-config.json")
+
+	origGetConfigFilePath := config.GetConfigFilePath
+	defer func() {
+		config.GetConfigFilePath = origGetConfigFilePath
+	}()
+	config.GetConfigFilePath = func() string {
+		return configFile
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case auth.TokenEndpoint:
+			w.Write([]byte(`{"access_token": "memory-token", "token_type": "Bearer", "expires_in": 3600}`))
+		case auth.GetAppCredsEndpoint:
+			if r.Header.Get("Authorization") != "Bearer memory-token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`{"Result": {"Password": "test-credential"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		TenantURL:    server.URL,
+		ClientID:     "test-client-id",
+		ClientSecret: "test-client-secret",
+	}
+	if err := config.SaveConfig(cfg, configFile); err != nil {
+		t.Fatalf("Failed to save config: %v", err)
+	}
+
+	p, err := NewMemoryProvider(false)
+	if err != nil {
+		t.Fatalf("NewMemoryProvider failed: %v", err)
+	}
+
+	credential, err := p.GetCredential("test-app-id")
+	if err != nil || credential != "test-credential" {
+		t.Fatalf("GetCredential = %q, %v", credential, err)
+	}
+
+	savedCfg, err := config.LoadConfig(configFile)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if savedCfg.AuthToken != "" {
+		t.Errorf("Token was written to the config file: %s", savedCfg.AuthToken)
+	}
+}
+
+func TestCredentialCache(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "summon-wpm-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	configFile := filepath.Join(tmpDir, "test-config.json")
+	t.Setenv("SUMMON_WPM_CACHE_DIR", filepath.Join(tmpDir, "cache"))
+
+	origGetConfigFilePath := config.GetConfigFilePath
+	origKeyringKey := keyringKey
+	defer func() {
+		config.GetConfigFilePath = origGetConfigFilePath
+		keyringKey = origKeyringKey
+	}()
+	config.GetConfigFilePath = func() string {
+		return configFile
+	}
+	key := cache.NewKey()
+	keyringKey = func() ([]byte, error) {
+		return key, nil
+	}
+
+	fetches := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != auth.GetAppCredsEndpoint {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fetches++
+		fmt.Fprintf(w, `{"Result": {"Username": "user", "Password": "secret-%d"}}`, fetches)
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		TenantURL:   server.URL,
+		Username:    "alice",
+		AuthToken:   "valid-token",
+		TokenExpiry: time.Now().Add(1 * time.Hour).Unix(),
+		Cache: &config.CacheConfig{
+			TTL:  "1h",
+			Apps: map[string]string{"uncached-app": "0"},
+		},
+	}
+	if err := config.SaveConfig(cfg, configFile); err != nil {
+		t.Fatalf("Failed to save config: %v", err)
+	}
+
+	get := func(p *Provider, id string) string {
+		t.Helper()
+		value, err := p.GetCredential(id)
+		if err != nil {
+			t.Fatalf("GetCredential(%s) failed: %v", id, err)
+		}
+		return value
+	}
+
+	p := NewProvider(false)
+	if first, second := get(p, "app-1"), get(p, "app-1"); first != "secret-1" || second != "secret-1" {
+		t.Errorf("Expected the cached credential, got %s then %s", first, second)
+	}
+	if value := get(p.WithoutCache(), "app-1"); value != "secret-2" {
+		t.Errorf("WithoutCache = %s, want a fresh fetch", value)
+	}
+	if first, second := get(p, "uncached-app"), get(p, "uncached-app"); first == second {
+		t.Errorf("App with TTL 0 was cached: %s", first)
+	}
+
+	// Another identity on the same tenant does not share entries
+	cfg.Username = "bob"
+	if err := config.SaveConfig(cfg, configFile); err != nil {
+		t.Fatalf("Failed to save config: %v", err)
+	}
+	if value := get(p, "app-1"); value != "secret-5" {
+		t.Errorf("Other identity got %s, want a fresh fetch", value)
+	}
+
+	// Batches use the cache too, for the items it has
+	results, err := p.GetCredentials([]string{"app-1", "app-2"}, 2, false)
+	if err != nil {
+		t.Fatalf("GetCredentials failed: %v", err)
+	}
+	if results[0].Value != "secret-5" || results[1].Value != "secret-6" || fetches != 6 {
+		t.Errorf("GetCredentials = %+v after %d fetches", results, fetches)
+	}
+}
+
+func TestStaleIfError(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "summon-wpm-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	configFile := filepath.Join(tmpDir, "test-config.json")
+	t.Setenv("SUMMON_WPM_CACHE_DIR", filepath.Join(tmpDir, "cache"))
+
+	origGetConfigFilePath := config.GetConfigFilePath
+	origKeyringKey := keyringKey
+	defer func() {
+		config.GetConfigFilePath = origGetConfigFilePath
+		keyringKey = origKeyringKey
+	}()
+	config.GetConfigFilePath = func() string {
+		return configFile
+	}
+	key := cache.NewKey()
+	keyringKey = func() ([]byte, error) {
+		return key, nil
+	}
+
+	status, refuse := http.StatusOK, false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != auth.GetAppCredsEndpoint {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+		if refuse {
+			w.Write([]byte(`{"Result": {}}`))
+			return
+		}
+		w.Write([]byte(`{"Result": {"Password": "last-good"}}`))
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		TenantURL:   server.URL,
+		Username:    "alice",
+		AuthToken:   "valid-token",
+		TokenExpiry: time.Now().Add(1 * time.Hour).Unix(),
+		Cache: &config.CacheConfig{
+			StaleIfError: map[string]string{"app-1": "1h", "app-2": "1ns"},
+		},
+	}
+	if err := config.SaveConfig(cfg, configFile); err != nil {
+		t.Fatalf("Failed to save config: %v", err)
+	}
+
+	p := NewProvider(false)
+	for _, id := range []string{"app-1", "app-2", "app-3"} {
+		if _, err := p.GetCredential(id); err != nil {
+			t.Fatalf("GetCredential(%s) failed: %v", id, err)
+		}
+	}
+
+	// Without a TTL the cache is not used while the tenant answers
+	status = http.StatusServiceUnavailable
+	value, err := p.GetCredential("app-1")
+	var stale *cache.StaleError
+	if value != "last-good" || !errors.As(err, &stale) || stale.ID != "app-1" {
+		t.Errorf("GetCredential during outage = %q, %v; want the stale value", value, err)
+	}
+
+	// Too old, or not opted in
+	for _, id := range []string{"app-2", "app-3"} {
+		if value, err := p.GetCredential(id); err == nil || errors.As(err, &stale) || value != "" {
+			t.Errorf("GetCredential(%s) = %q, %v; want the outage error", id, value, err)
+		}
+	}
+
+	results, err := p.GetCredentials([]string{"app-1", "app-3"}, 2, true)
+	if err != nil {
+		t.Fatalf("GetCredentials failed: %v", err)
+	}
+	if results[0].Value != "last-good" || !errors.As(results[0].Err, &stale) || results[1].Err == nil || errors.As(results[1].Err, &stale) {
+		t.Errorf("GetCredentials during outage = %+v", results)
+	}
+
+	// A tenant that answers but refuses is not an outage
+	status, refuse = http.StatusOK, true
+	if _, err := p.GetCredential("app-1"); err == nil || errors.As(err, &stale) {
+		t.Errorf("Expected the tenant's refusal, got %v", err)
+	}
+}
+
+func TestStaleIfErrorDuringLogin(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "summon-wpm-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	configFile := filepath.Join(tmpDir, "test-config.json")
+	t.Setenv("SUMMON_WPM_CACHE_DIR", filepath.Join(tmpDir, "cache"))
+
+	origGetConfigFilePath := config.GetConfigFilePath
+	origKeyringKey := keyringKey
+	defer func() {
+		config.GetConfigFilePath = origGetConfigFilePath
+		keyringKey = origKeyringKey
+	}()
+	config.GetConfigFilePath = func() string {
+		return configFile
+	}
+	key := cache.NewKey()
+	keyringKey = func() ([]byte, error) {
+		return key, nil
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"Result": {"Password": "last-good"}}`))
+	}))
+
+	cfg := &config.Config{
+		TenantURL:     server.URL,
+		Username:      "alice",
+		AuthToken:     "valid-token",
+		TokenExpiry:   time.Now().Add(1 * time.Hour).Unix(),
+		OAuthAppID:    "app",
+		OAuthClientID: "client",
+		Cache: &config.CacheConfig{
+			StaleIfError: map[string]string{"app-1": "1h"},
+		},
+	}
+	if err := config.SaveConfig(cfg, configFile); err != nil {
+		t.Fatalf("Failed to save config: %v", err)
+	}
+	if _, err := NewProvider(false).GetCredential("app-1"); err != nil {
+		t.Fatalf("GetCredential failed: %v", err)
+	}
+
+	// The token has expired and the tenant cannot be reached to refresh it
+	server.Close()
+	cfg.TokenExpiry = time.Now().Add(-1 * time.Minute).Unix()
+	cfg.RefreshToken = "refresh-token"
+	if err := config.SaveConfig(cfg, configFile); err != nil {
+		t.Fatalf("Failed to save config: %v", err)
+	}
+
+	value, err := NewProvider(false).GetCredential("app-1")
+	var stale *cache.StaleError
+	if value != "last-good" || !errors.As(err, &stale) {
+		t.Errorf("GetCredential during outage = %q, %v; want the stale value", value, err)
+	}
+}
+
+func TestIsUnavailableError(t *testing.T) {
+	dialErr := &url.Error{Op: "Post", URL: "https://tenant", Err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}}
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"network error", fmt.Errorf("app credentials request failed: %w", dialErr), true},
+		{"server error", fmt.Errorf("re-authentication failed: %w", &api.StatusError{StatusCode: http.StatusBadGateway, Status: "502 Bad Gateway"}), true},
+		{"refused", &api.StatusError{StatusCode: http.StatusForbidden, Status: "403 Forbidden"}, false},
+		{"certificate", &url.Error{Op: "Get", URL: "https://tenant", Err: errors.New("x509: certificate signed by unknown authority")}, false},
+		{"message only", errors.New("service unavailable: timeout"), false},
+	}
+	for _, tt := range tests {
+		if got := isUnavailableError(tt.err); got != tt.want {
+			t.Errorf("%s: isUnavailableError() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package provider
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/infamousjoeg/summon-wpm/internal/auth"
+	"github.com/infamousjoeg/summon-wpm/internal/config"
+	"github.com/infamousjoeg/summon-wpm/internal/selector"
+)
+
+// renewBefore is how long before expiry an agent renews its session
+const renewBefore = 5 * time.Minute
+
+// sharedSession shares one token between concurrent lookups. Requests hold
+// the read lock; logging in or replacing a rejected token takes the write
+// lock. An empty configFile keeps the tokens in memory only.
+type sharedSession struct {
+	provider   *Provider
+	cfg        *config.Config
+	configFile string
+	lock       sync.RWMutex
+	warned     bool
+
+	// cache holds fetched credentials when the profile turns caching on
+	cache *credentialCache
+}
+
+// prepare logs in when the session has no usable token
+func (s *sharedSession) prepare() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.provider.authenticate(s.cfg, s.configFile)
+}
+
+// do runs a request with the token, logging in again once if it is rejected
+func (s *sharedSession) do(request func(cfg *config.Config) error) error {
+	s.lock.RLock()
+	token := s.cfg.AuthToken
+	err := request(s.cfg)
+	s.lock.RUnlock()
+
+	if err == nil || !isAuthError(err) {
+		return err
+	}
+
+	// Only the first request to see the rejected token replaces it
+	s.lock.Lock()
+	if s.cfg.AuthToken == token {
+		if err := s.provider.reauthenticate(s.cfg, s.configFile); err != nil {
+			s.lock.Unlock()
+			return err
+		}
+	}
+	s.lock.Unlock()
+
+	// Try again with new token
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return request(s.cfg)
+}
+
+// fetch retrieves one credential
+func (s *sharedSession) fetch(id string) (string, error) {
+	appID, field := selector.Parse(id)
+
+	var credential string
+	err := s.do(func(cfg *config.Config) error {
+		var err error
+		credential, err = auth.GetAppCredentialField(cfg, appID, field)
+		return err
+	})
+	return credential, err
+}
+
+// renew replaces the token when it expires within renewBefore
+func (s *sharedSession) renew() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.cfg.TokenExpiry == 0 || time.Until(time.Unix(s.cfg.TokenExpiry, 0)) > renewBefore {
+		return nil
+	}
+
+	switch {
+	case s.cfg.RefreshToken != "":
+		return auth.RefreshAccessToken(s.cfg, s.configFile)
+	case auth.HasServiceCredentials(s.cfg):
+		return auth.AuthenticateService(s.cfg, s.configFile)
+	case !s.warned:
+		s.warned = true
+		return fmt.Errorf("the interactive session expires at %s and cannot be renewed in the background; the next request will log in again",
+			time.Unix(s.cfg.TokenExpiry, 0).Format(time.Kitchen))
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,232 @@
+package secretsyml
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Tag modifiers understood in secrets.yml, as in summon. A tag is a set of
+// modifiers separated by ":", e.g. !var:file or !file:var.
+const (
+	modifierVar     = "var"
+	modifierFile    = "file"
+	modifierStr     = "str"
+	modifierDefault = "default="
+)
+
+// commonSections are merged into every environment
+var commonSections = []string{"common", "default"}
+
+// Secret is one variable from secrets.yml
+type Secret struct {
+	// Name is the environment variable set for the child
+	Name string
+	// Value is the app_id to look up for a variable, or the literal value
+	Value string
+	// IsVar is set when Value must be looked up with the provider
+	IsVar bool
+	// IsFile is set when the value is written to a temp file and the
+	// variable holds the file's path
+	IsFile bool
+	// Default is used when a variable cannot be looked up, if HasDefault
+	Default    string
+	HasDefault bool
+}
+
+// ParseFile reads a secrets.yml file. See Parse.
+func ParseFile(path, environment string, defines map[string]string) ([]Secret, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data, environment, defines)
+}
+
+// Parse reads secrets.yml content in document order. When environment is
+// set, that top-level section is used, on top of any common or default
+// section. $NAME and ${NAME} in values are replaced with defines; $$ is a
+// literal $.
+func Parse(data []byte, environment string, defines map[string]string) ([]Secret, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("error parsing secrets.yml: %s", err)
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, errors.New("secrets.yml must be a mapping of variable names to values")
+	}
+
+	if environment == "" {
+		return parseSection(root, defines)
+	}
+
+	var sections []*yaml.Node
+	for _, name := range append(commonSections, environment) {
+		section := lookup(root, name)
+		if section == nil {
+			if name == environment {
+				return nil, fmt.Errorf("no section for environment %q in secrets.yml", environment)
+			}
+			continue
+		}
+		if section.Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("section %q must be a mapping", name)
+		}
+		sections = append(sections, section)
+	}
+
+	// Later sections override earlier ones, keeping the first position
+	var secrets []Secret
+	index := map[string]int{}
+	for _, section := range sections {
+		parsed, err := parseSection(section, defines)
+		if err != nil {
+			return nil, err
+		}
+		for _, secret := range parsed {
+			if i, ok := index[secret.Name]; ok {
+				secrets[i] = secret
+				continue
+			}
+			index[secret.Name] = len(secrets)
+			secrets = append(secrets, secret)
+		}
+	}
+	return secrets, nil
+}
+
+// lookup returns the value for key in a mapping node
+func lookup(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// parseSection reads the variables of one mapping
+func parseSection(section *yaml.Node, defines map[string]string) ([]Secret, error) {
+	var secrets []Secret
+	for i := 0; i+1 < len(section.Content); i += 2 {
+		name := section.Content[i].Value
+		value := section.Content[i+1]
+
+		if value.Kind != yaml.ScalarNode {
+			return nil, fmt.Errorf("%s: value must be a string", name)
+		}
+
+		secret := Secret{Name: name}
+		switch value.Tag {
+		case "!!str", "!!int", "!!float", "!!bool", "!!null", "":
+		default:
+			if err := parseTag(value.Tag, &secret); err != nil {
+				return nil, fmt.Errorf("%s: %s", name, err)
+			}
+		}
+
+		interpolated, err := interpolate(value.Value, defines)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+		secret.Value = interpolated
+
+		if secret.IsVar && secret.Value == "" {
+			return nil, fmt.Errorf("%s: missing app_id", name)
+		}
+		secrets = append(secrets, secret)
+	}
+	return secrets, nil
+}
+
+// parseTag applies the modifiers of a tag such as !var:file or
+// !var:default='value' to secret
+func parseTag(tag string, secret *Secret) error {
+	if !strings.HasPrefix(tag, "!") || len(tag) == 1 {
+		return fmt.Errorf("unknown tag %s", tag)
+	}
+
+	isStr := false
+	rest := tag[1:]
+	for rest != "" {
+		if strings.HasPrefix(rest, modifierDefault+"'") {
+			// The quoted value may itself contain ":"
+			value := rest[len(modifierDefault)+1:]
+			end := strings.Index(value, "'")
+			if end < 0 {
+				return fmt.Errorf("unterminated default in tag %s", tag)
+			}
+			secret.Default, secret.HasDefault = value[:end], true
+			rest = value[end+1:]
+		} else {
+			modifier := rest
+			if i := strings.Index(rest, ":"); i >= 0 {
+				modifier, rest = rest[:i], rest[i:]
+			} else {
+				rest = ""
+			}
+
+			switch {
+			case modifier == modifierVar:
+				secret.IsVar = true
+			case modifier == modifierFile:
+				secret.IsFile = true
+			case modifier == modifierStr:
+				isStr = true
+			case strings.HasPrefix(modifier, modifierDefault):
+				secret.Default, secret.HasDefault = strings.TrimPrefix(modifier, modifierDefault), true
+			default:
+				return fmt.Errorf("unknown tag %s", tag)
+			}
+		}
+
+		// Modifiers are separated by ":"
+		if rest != "" {
+			if rest[0] != ':' || len(rest) == 1 {
+				return fmt.Errorf("unknown tag %s", tag)
+			}
+			rest = rest[1:]
+		}
+	}
+
+	if isStr && secret.IsVar {
+		return fmt.Errorf("tag %s is both var and str", tag)
+	}
+	if secret.HasDefault && !secret.IsVar {
+		return fmt.Errorf("tag %s has a default but is not a var", tag)
+	}
+	return nil
+}
+
+// variablePattern matches $$, $NAME and ${NAME}
+var variablePattern = regexp.MustCompile(`\$\$|\$\{([A-Za-z_][A-Za-z0-9_]*)\}|\$([A-Za-z_][A-Za-z0-9_]*)`)
+
+// interpolate replaces $NAME and ${NAME} with their defines
+func interpolate(value string, defines map[string]string) (string, error) {
+	var missing []string
+	result := variablePattern.ReplaceAllStringFunc(value, func(match string) string {
+		if match == "$$" {
+			return "$"
+		}
+		name := strings.Trim(match, "${}")
+		if replacement, ok := defines[name]; ok {
+			return replacement
+		}
+		missing = append(missing, name)
+		return match
+	})
+
+	if len(missing) > 0 {
+		return "", fmt.Errorf("no value for $%s (define it with -D %s=value)", missing[0], missing[0])
+	}
+	return result, nil
+}
@@ -0,0 +1,120 @@
+package secretsyml
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	data := []byte(`
+DB_PASSWORD: !var db-app-key
+TLS_CERT: !var:file cert-app-key
+REGION: !str us-east-1
+PORT: 5432
+CONFIG: !str:file "key: value"
+DB_URL: postgres://$DB_HOST/app
+PRICE: !str $$5
+`)
+
+	secrets, err := Parse(data, "", map[string]string{"DB_HOST": "db.example.com"})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	expected := []Secret{
+		{Name: "DB_PASSWORD", Value: "db-app-key", IsVar: true},
+		{Name: "TLS_CERT", Value: "cert-app-key", IsVar: true, IsFile: true},
+		{Name: "REGION", Value: "us-east-1"},
+		{Name: "PORT", Value: "5432"},
+		{Name: "CONFIG", Value: "key: value", IsFile: true},
+		{Name: "DB_URL", Value: "postgres://db.example.com/app"},
+		{Name: "PRICE", Value: "$5"},
+	}
+	if !reflect.DeepEqual(secrets, expected) {
+		t.Errorf("Parse = %+v\nwant %+v", secrets, expected)
+	}
+}
+
+func TestParseEnvironment(t *testing.T) {
+	data := []byte(`
+common:
+  DB_USER: !str app
+  DB_PASSWORD: !var dev-db
+production:
+  DB_PASSWORD: !var prod-db
+  API_KEY: !var prod-api
+`)
+
+	secrets, err := Parse(data, "production", nil)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	expected := []Secret{
+		{Name: "DB_USER", Value: "app"},
+		{Name: "DB_PASSWORD", Value: "prod-db", IsVar: true},
+		{Name: "API_KEY", Value: "prod-api", IsVar: true},
+	}
+	if !reflect.DeepEqual(secrets, expected) {
+		t.Errorf("Parse = %+v\nwant %+v", secrets, expected)
+	}
+
+	if _, err := Parse(data, "staging", nil); err == nil {
+		t.Error("Expected an error for a missing environment")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"Unknown tag", "A: !secret x"},
+		{"Undefined variable", "A: !var $APP"},
+		{"Missing app_id", "A: !var"},
+		{"Nested value", "A: {B: c}"},
+		{"Not a mapping", "- a"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse([]byte(tt.data), "", nil); err == nil {
+				t.Errorf("Expected an error for %q", tt.data)
+			}
+		})
+	}
+}
+
+func TestParseTagModifiers(t *testing.T) {
+	data := []byte(`
+TLS_CERT: !file:var cert-app-key
+DB_PASSWORD: !var:default='local:pass' db-app-key
+API_KEY: !var:file:default=fallback api-app-key
+`)
+
+	secrets, err := Parse(data, "", nil)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	expected := []Secret{
+		{Name: "TLS_CERT", Value: "cert-app-key", IsVar: true, IsFile: true},
+		{Name: "DB_PASSWORD", Value: "db-app-key", IsVar: true, Default: "local:pass", HasDefault: true},
+		{Name: "API_KEY", Value: "api-app-key", IsVar: true, IsFile: true, Default: "fallback", HasDefault: true},
+	}
+	if !reflect.DeepEqual(secrets, expected) {
+		t.Errorf("Parse = %+v\nwant %+v", secrets, expected)
+	}
+
+	for _, invalid := range []string{
+		"X: !var:str app",
+		"X: !bogus app",
+		"X: !var: app",
+		"X: !str:default='x' value",
+		"X: !var:default='open app",
+	} {
+		if _, err := Parse([]byte(invalid), "", nil); err == nil {
+			t.Errorf("Parse(%q) succeeded", invalid)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+// Package selector reads and writes credential IDs that select one field of
+// an app's credentials, as in "my-app#Username".
+package selector
+
+import "strings"
+
+// Separator separates the app ID from the field
+const Separator = "#"
+
+// Parse splits "app_id#field" into the app ID and the field. Without a field
+// the password is selected.
+func Parse(id string) (appID, field string) {
+	if i := strings.LastIndex(id, Separator); i > 0 {
+		return id[:i], id[i+1:]
+	}
+	return id, ""
+}
+
+// Format returns the ID that Parse splits into appID and field. An app ID
+// that itself contains the separator is given an empty field, so it is never
+// mistaken for a selector.
+func Format(appID, field string) string {
+	if field == "" && !strings.Contains(appID, Separator) {
+		return appID
+	}
+	return appID + Separator + field
+}
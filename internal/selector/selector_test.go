@@ -0,0 +1,34 @@
+package selector
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		id    string
+		appID string
+		field string
+	}{
+		{"my-app", "my-app", ""},
+		{"my-app#Username", "my-app", "Username"},
+		{"#odd", "#odd", ""},
+		{"a#b#", "a#b", ""},
+	}
+
+	for _, tt := range tests {
+		appID, field := Parse(tt.id)
+		if appID != tt.appID || field != tt.field {
+			t.Errorf("Parse(%q) = %q, %q; want %q, %q", tt.id, appID, field, tt.appID, tt.field)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	for _, appID := range []string{"my-app", "team#db", "#odd"} {
+		if got, field := Parse(Format(appID, "")); got != appID || field != "" {
+			t.Errorf("Parse(Format(%q, \"\")) = %q, %q", appID, got, field)
+		}
+	}
+	if id := Format("my-app", "Username"); id != "my-app#Username" {
+		t.Errorf("Format = %q, want my-app#Username", id)
+	}
+}